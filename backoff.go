@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backoff settings for the upstream APIs
+const (
+	backoffMaxFactor      = 32              // Blocked (403/429) responses slow polling down to 32x
+	backoffMaxFactor5xx   = 4               // Server errors slow it down less
+	backoffRampUpAfter    = 5               // Successes in a row before halving the factor
+	backoffMaxRetryAfter  = time.Hour       // Ignore longer Retry-After values
+	backoffDefaultBlocked = 2 * time.Minute // Pause after a 403/429 without Retry-After
+)
+
+// UpstreamBackoff slows polling down when the upstream APIs push back. All
+// targets share it since NVIDIA's edge limits per client IP.
+type UpstreamBackoff struct {
+	factor      int       // Multiplier for the configured intervals
+	successes   int       // Successful responses since the last change of factor
+	pausedUntil time.Time // No requests before this, from Retry-After
+	mu          sync.Mutex
+}
+
+var upstreamBackoff = &UpstreamBackoff{factor: 1}
+
+// Observe adjusts the backoff to an upstream response
+func (b *UpstreamBackoff) Observe(resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch code := resp.StatusCode; {
+	case code == http.StatusOK:
+		b.successes++
+		if b.factor > 1 && b.successes >= backoffRampUpAfter {
+			b.factor /= 2
+			b.successes = 0
+			log.Printf("Upstream recovered, polling at %dx the configured interval", b.factor)
+		}
+	case code == http.StatusTooManyRequests || code == http.StatusForbidden:
+		b.slowDown(backoffMaxFactor)
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		if !ok {
+			wait = backoffDefaultBlocked
+		}
+		b.pause(now.Add(wait))
+		log.Printf("Upstream returned %d, pausing for %v and polling at %dx the configured interval",
+			code, wait.Round(time.Second), b.factor)
+	case code >= 500:
+		b.slowDown(backoffMaxFactor5xx)
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			b.pause(now.Add(wait))
+		}
+	}
+}
+
+func (b *UpstreamBackoff) slowDown(maxFactor int) {
+	b.successes = 0
+	if b.factor < maxFactor {
+		b.factor *= 2
+	}
+}
+
+func (b *UpstreamBackoff) pause(until time.Time) {
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// Interval returns the effective polling interval for a configured one,
+// stretched to the end of a Retry-After pause
+func (b *UpstreamBackoff) Interval(configured time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	interval := configured * time.Duration(b.factor)
+	if wait := time.Until(b.pausedUntil); wait > interval {
+		interval = wait
+	}
+	return interval
+}
+
+// Paused reports whether requests should wait for a Retry-After to pass
+func (b *UpstreamBackoff) Paused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.pausedUntil)
+}
+
+// Factor returns the current interval multiplier
+func (b *UpstreamBackoff) Factor() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.factor
+}
+
+// PollingStatus is the effective polling view exposed in /status
+type PollingStatus struct {
+	StockIntervalMs int64      `json:"stock_interval_ms"`
+	SkuIntervalMs   int64      `json:"sku_interval_ms"`
+	BackoffFactor   int        `json:"backoff_factor"`
+	PausedUntil     *time.Time `json:"paused_until,omitempty"`
+}
+
+func (b *UpstreamBackoff) Status(intervals Intervals) PollingStatus {
+	status := PollingStatus{
+		StockIntervalMs: b.Interval(intervals.Stock).Milliseconds(),
+		SkuIntervalMs:   b.Interval(intervals.Sku).Milliseconds(),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status.BackoffFactor = b.factor
+	if time.Now().Before(b.pausedUntil) {
+		until := b.pausedUntil
+		status.PausedUntil = &until
+	}
+	return status
+}
+
+// Parse a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		wait = t.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > backoffMaxRetryAfter {
+		wait = backoffMaxRetryAfter
+	}
+	return wait, true
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config file settings
+const (
+	DEFAULT_CONFIG_FILE  = "config.toml" // Read from the working directory if it exists
+	configReloadInterval = 5 * time.Second
+)
+
+// Every setting the tracker reads. A config file key maps to the setting of
+// the same name in upper case, keys in a [section] get the section as
+// prefix: [ntfy] topic = "x" sets NTFY_TOPIC.
+var knownSettings = map[string]bool{
+	"NVIDIA_PRODUCT_URL": true, "STOCK_CHECK_INTERVAL": true, "SKU_CHECK_INTERVAL": true,
+	"STOCK_REALERT_INTERVAL": true, "DAILY_REPORT_TIME": true, "ERROR_THRESHOLD": true, "ERROR_WINDOW": true,
+	"DATA_DIR": true, "EVENT_RETENTION_DAYS": true, "READY_MAX_MISSED_CHECKS": true,
+	"NOTIFY_CHANNELS": true, "NOTIFY_QUEUE_SIZE": true, "NOTIFY_WORKERS": true, "NOTIFY_PROXY": true,
+	"OUTBOX_MAX_STOCK_AGE": true, "PUBLIC_URL": true,
+	"NTFY_TOPIC": true, "NTFY_SERVER": true, "NTFY_TOKEN": true, "NTFY_USERNAME": true, "NTFY_PASSWORD": true,
+	"DISCORD_WEBHOOK_URL": true, "TELEGRAM_BOT_TOKEN": true, "TELEGRAM_CHAT_ID": true,
+	"SMTP_HOST": true, "SMTP_PORT": true, "SMTP_FROM": true, "SMTP_TO": true,
+	"SMTP_USERNAME": true, "SMTP_PASSWORD": true, "WEBHOOK_URL": true,
+	"UPSTREAM_PROXIES": true, "PROXY_ROTATION": true, "PROXY_MAX_FAILURES": true, "PROXY_QUARANTINE": true,
+	"HEADER_PROFILES": true, "HEADER_ROTATION": true,
+}
+
+// Config file keys with a friendlier name than their setting
+var settingAliases = map[string]string{
+	"TARGETS": "NVIDIA_PRODUCT_URL",
+}
+
+// Settings are layered: -set flags override environment variables, which
+// override the config file. Alert rules only come from the file.
+var (
+	configPath   string
+	fileSettings = map[string]string{}
+	fileRules    []RuleSpec
+	flagSettings = map[string]string{}
+	settingsMu   sync.RWMutex
+)
+
+// configFile is the parsed content of a config file
+type configFile struct {
+	settings map[string]string
+	rules    []RuleSpec // [rule.NAME] sections in file order
+}
+
+// Rule sections currently loaded from the config file
+func configRules() []RuleSpec {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return fileRules
+}
+
+// Current value of a setting, empty if unset
+func setting(key string) string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	if value, ok := flagSettings[key]; ok {
+		return value
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fileSettings[key]
+}
+
+// settingFlags collects repeated -set KEY=VALUE flags
+type settingFlags map[string]string
+
+func (f settingFlags) String() string { return "" }
+
+func (f settingFlags) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	key = strings.ToUpper(strings.TrimSpace(key))
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE")
+	}
+	if !knownSettings[key] {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	f[key] = v
+	return nil
+}
+
+// Load the config file from -config, CONFIG_FILE or config.toml in the
+// working directory. Only an explicitly given file has to exist.
+func loadConfigFile(path string) error {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		if _, err := os.Stat(DEFAULT_CONFIG_FILE); err != nil {
+			return nil
+		}
+		path = DEFAULT_CONFIG_FILE
+	}
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	configPath = path
+	fileSettings, fileRules = file.settings, file.rules
+	settingsMu.Unlock()
+	log.Printf("- CONFIG_FILE: %s (%d settings, %d rules)", path, len(file.settings), len(file.rules))
+	return nil
+}
+
+func readConfigFile(path string) (configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return configFile{}, err
+	}
+	return parseConfig(path, string(data))
+}
+
+// Parse the TOML subset used by config files: [section] headers, key = value
+// pairs with string, integer, boolean or array values, and # comments.
+// Arrays become comma separated settings. [rule.NAME] sections hold alert
+// rules instead of settings.
+func parseConfig(name, data string) (configFile, error) {
+	settings := make(map[string]string)
+	var rules []RuleSpec
+	var rule *RuleSpec
+	section := ""
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			header := strings.TrimSpace(strings.TrimSuffix(line[1:], "]"))
+			ruleName, isRule := strings.CutPrefix(header, "rule.")
+			if !strings.HasSuffix(line, "]") || !validConfigKey(strings.TrimPrefix(header, "rule.")) {
+				return configFile{}, fmt.Errorf("%s:%d: invalid section header %s", name, lineNo, line)
+			}
+			section, rule = header, nil
+			if isRule {
+				for _, existing := range rules {
+					if existing.Name == ruleName {
+						return configFile{}, fmt.Errorf("%s:%d: rule %s is defined twice", name, lineNo, ruleName)
+					}
+				}
+				rules = append(rules, RuleSpec{Name: ruleName, Line: lineNo, Values: make(map[string]string)})
+				rule = &rules[len(rules)-1]
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !validConfigKey(key) {
+			return configFile{}, fmt.Errorf("%s:%d: expected key = value", name, lineNo)
+		}
+
+		// Arrays may continue over several lines
+		for strings.HasPrefix(value, "[") && arrayDepth(value) > 0 && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		parsed, err := parseConfigValue(value)
+		if err != nil {
+			return configFile{}, fmt.Errorf("%s:%d: %s: %v", name, lineNo, key, err)
+		}
+
+		if rule != nil {
+			if !ruleKeys[key] {
+				return configFile{}, fmt.Errorf("%s:%d: unknown rule key %s", name, lineNo, key)
+			}
+			if _, dup := rule.Values[key]; dup {
+				return configFile{}, fmt.Errorf("%s:%d: %s is set twice", name, lineNo, key)
+			}
+			rule.Values[key] = parsed
+			continue
+		}
+
+		settingName := key
+		if section != "" {
+			settingName = section + "_" + key
+		}
+		settingName = strings.ToUpper(strings.ReplaceAll(settingName, "-", "_"))
+		if alias, ok := settingAliases[settingName]; ok {
+			settingName = alias
+		}
+		if !knownSettings[settingName] {
+			return configFile{}, fmt.Errorf("%s:%d: unknown setting %s", name, lineNo, key)
+		}
+		if _, dup := settings[settingName]; dup {
+			return configFile{}, fmt.Errorf("%s:%d: %s is set twice", name, lineNo, key)
+		}
+		settings[settingName] = parsed
+	}
+	return configFile{settings: settings, rules: rules}, nil
+}
+
+func validConfigKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Cut a # comment that isn't inside a string
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Open brackets outside of strings
+func arrayDepth(value string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+func parseConfigValue(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return s, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") || strings.Contains(value[1:len(value)-1], "'") {
+			return "", fmt.Errorf("invalid string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, "["):
+		return parseConfigArray(value)
+	case value == "true" || value == "false":
+		return value, nil
+	}
+
+	number := strings.ReplaceAll(value, "_", "")
+	if _, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return number, nil
+	}
+	if _, err := strconv.ParseFloat(number, 64); err == nil {
+		return number, nil
+	}
+	return "", fmt.Errorf("invalid value %s, strings need quotes", value)
+}
+
+// Parse a single-level array into a comma separated list
+func parseConfigArray(value string) (string, error) {
+	if arrayDepth(value) != 0 || !strings.HasSuffix(value, "]") {
+		return "", fmt.Errorf("unterminated array")
+	}
+	inner := value[1 : len(value)-1]
+
+	var items []string
+	start := 0
+	var quote rune
+	escaped := false
+	for i, r := range inner + "," {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			return "", fmt.Errorf("nested arrays are not supported")
+		case r == ',':
+			item := strings.TrimSpace((inner + ",")[start:i])
+			start = i + 1
+			if item == "" {
+				continue // Trailing comma
+			}
+			parsed, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, parsed)
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+// Parse an interval given in milliseconds ("1000") or as a duration ("1s")
+func parseInterval(value string) (time.Duration, error) {
+	var d time.Duration
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		d = time.Duration(ms) * time.Millisecond
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("%q is neither milliseconds nor a duration like 5s", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%q is negative", value)
+	}
+	return d, nil
+}
+
+// Config that is currently applied
+var (
+	activeConfig Config
+	activeMu     sync.RWMutex
+)
+
+func currentConfig() Config {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return activeConfig
+}
+
+// Apply the parts of config that can change at runtime. Monitors, SSE
+// clients and metrics are kept.
+func applyConfig(config Config) {
+	activeMu.Lock()
+	activeConfig = config
+	activeMu.Unlock()
+
+	errorTracker.mu.Lock()
+	errorTracker.Threshold = config.ErrorThreshold
+	errorTracker.Window = config.ErrorWindow
+	errorTracker.mu.Unlock()
+
+	if scheduler != nil {
+		scheduler.Apply(config.Targets, config.Intervals)
+	}
+}
+
+// Re-read the config file and apply it. An invalid file is rejected as a
+// whole and the running config stays in place.
+func reloadConfig() error {
+	settingsMu.RLock()
+	path := configPath
+	settingsMu.RUnlock()
+	if path == "" {
+		return fmt.Errorf("no config file loaded")
+	}
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	previousSettings, previousRules := fileSettings, fileRules
+	fileSettings, fileRules = file.settings, file.rules
+	settingsMu.Unlock()
+
+	config, err := loadConfig()
+	var channels []*notifierChannel
+	var rules []*Rule
+	if err == nil {
+		channels, err = buildNotifiers()
+	}
+	if err == nil {
+		rules, err = compileRules(file.rules, channels)
+	}
+	if err != nil {
+		settingsMu.Lock()
+		fileSettings, fileRules = previousSettings, previousRules
+		settingsMu.Unlock()
+		return err
+	}
+
+	applyConfig(config)
+	setNotifiers(channels)
+	setRules(rules)
+	log.Printf("Configuration reloaded from %s", path)
+	return nil
+}
+
+// Reload the config file on SIGHUP and whenever its modification time
+// changes, until ctx is cancelled
+func watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	settingsMu.RLock()
+	path := configPath
+	settingsMu.RUnlock()
+	lastMod := modTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP received, reloading configuration")
+		case <-ticker.C:
+			mod := modTime(path)
+			if path == "" || mod.Equal(lastMod) {
+				continue
+			}
+			log.Printf("%s changed, reloading configuration", path)
+		}
+
+		lastMod = modTime(path)
+		if err := reloadConfig(); err != nil {
+			log.Printf("Configuration reload failed, keeping the current config: %v", err)
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "top level and sections",
+			data: `
+stock_check_interval = "1s"
+sku_check_interval = 10_000
+
+[ntfy]
+topic = 'fe-alerts'
+server = "https://ntfy.example.com"
+
+[error]
+threshold = 3
+`,
+			want: map[string]string{
+				"STOCK_CHECK_INTERVAL": "1s",
+				"SKU_CHECK_INTERVAL":   "10000",
+				"NTFY_TOPIC":           "fe-alerts",
+				"NTFY_SERVER":          "https://ntfy.example.com",
+				"ERROR_THRESHOLD":      "3",
+			},
+		},
+		{
+			name: "targets alias and multi-line array",
+			data: `
+targets = [
+  "https://marketplace.nvidia.com/de-de/consumer/graphics-cards/nvidia-geforce-rtx-5080/", # 5080
+  "https://marketplace.nvidia.com/fr-fr/consumer/graphics-cards/nvidia-geforce-rtx-5090/",
+]
+`,
+			want: map[string]string{
+				"NVIDIA_PRODUCT_URL": "https://marketplace.nvidia.com/de-de/consumer/graphics-cards/nvidia-geforce-rtx-5080/," +
+					"https://marketplace.nvidia.com/fr-fr/consumer/graphics-cards/nvidia-geforce-rtx-5090/",
+			},
+		},
+		{
+			name: "single-line array",
+			data: `[notify]
+channels = ["ntfy", 'discord']`,
+			want: map[string]string{"NOTIFY_CHANNELS": "ntfy,discord"},
+		},
+		{
+			name: "hash inside strings",
+			data: `[ntfy]
+topic = "x#y" # comment
+password = 'a#b'`,
+			want: map[string]string{"NTFY_TOPIC": "x#y", "NTFY_PASSWORD": "a#b"},
+		},
+		{
+			name: "escapes in double quoted strings",
+			data: `[ntfy]
+topic = "say \"hi\" # not a comment"`,
+			want: map[string]string{"NTFY_TOPIC": `say "hi" # not a comment`},
+		},
+		{
+			name: "dashes in keys",
+			data: `[discord]
+webhook-url = "https://discord.com/api/webhooks/1"`,
+			want: map[string]string{"DISCORD_WEBHOOK_URL": "https://discord.com/api/webhooks/1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parseConfig("config.toml", tt.data)
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			if !reflect.DeepEqual(file.settings, tt.want) {
+				t.Errorf("settings = %v, want %v", file.settings, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigRules(t *testing.T) {
+	file, err := parseConfig("config.toml", `
+[notify]
+channels = ["ntfy"]
+
+[rule.cheap]
+when = "price <= 1200 and locale in [de-de, at-de]"
+channels = ["ntfy"]
+priority = 5
+
+[rule.night]
+when = "time >= 23:00 or time < 08:00" # quiet hours
+drop = true
+`)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if file.settings["NOTIFY_CHANNELS"] != "ntfy" {
+		t.Errorf("NOTIFY_CHANNELS = %q", file.settings["NOTIFY_CHANNELS"])
+	}
+
+	want := []RuleSpec{
+		{Name: "cheap", Line: 5, Values: map[string]string{
+			"when":     "price <= 1200 and locale in [de-de, at-de]",
+			"channels": "ntfy",
+			"priority": "5",
+		}},
+		{Name: "night", Line: 10, Values: map[string]string{
+			"when": "time >= 23:00 or time < 08:00",
+			"drop": "true",
+		}},
+	}
+	if !reflect.DeepEqual(file.rules, want) {
+		t.Errorf("rules = %+v, want %+v", file.rules, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown setting", "bogus = 1", "config.toml:1: unknown setting bogus"},
+		{"unknown section key", "[error]\nwindow = 1\nbogus = 1", "config.toml:3: unknown setting bogus"},
+		{"duplicate key", "[ntfy]\ntopic = \"a\"\ntopic = \"b\"", "config.toml:3: topic is set twice"},
+		{"duplicate through alias", "targets = \"a\"\nnvidia_product_url = \"b\"", "config.toml:2: nvidia_product_url is set twice"},
+		{"unquoted string", "[ntfy]\ntopic = alerts", "config.toml:2: topic: invalid value alerts, strings need quotes"},
+		{"unterminated string", "[ntfy]\ntopic = \"alerts", "config.toml:2: topic: invalid string"},
+		{"unterminated array", "[notify]\nchannels = [\"ntfy\",\n\"discord\"", "config.toml:2: channels: unterminated array"},
+		{"nested array", "[notify]\nchannels = [[\"ntfy\"]]", "config.toml:2: channels: nested arrays are not supported"},
+		{"missing value", "[ntfy]\ntopic =", "config.toml:2: topic: missing value"},
+		{"missing equals", "[ntfy]\ntopic", "config.toml:2: expected key = value"},
+		{"bad section", "[ntfy", "config.toml:1: invalid section header [ntfy"},
+		{"dotted section", "[ntfy.extra]", "config.toml:1: invalid section header"},
+		{"empty rule name", "[rule.]", "config.toml:1: invalid section header"},
+		{"duplicate rule", "[rule.a]\ndrop = true\n[rule.a]\ndrop = false", "config.toml:3: rule a is defined twice"},
+		{"unknown rule key", "[rule.a]\nwhen = \"true\"\nurgent = true", "config.toml:3: unknown rule key urgent"},
+		{"duplicate rule key", "[rule.a]\ndrop = true\ndrop = false", "config.toml:3: drop is set twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig("config.toml", tt.data)
+			if err == nil {
+				t.Fatalf("parseConfig succeeded, want error %q", tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"1000", time.Second, true},
+		{"0", 0, true},
+		{"1s", time.Second, true},
+		{"5m", 5 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"-1", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseInterval(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseInterval(%q) = %v, %v; want %v, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Counter resolution and span
+const (
+	counterBucketWidth = time.Minute
+	counterBuckets     = 1440 // 24 hours of one-minute buckets
+)
+
+// WindowCounter counts events over the last 24 hours in fixed memory.
+// Each bucket remembers which minute it holds, so stale buckets are reset
+// lazily when they are reused and skipped when counting.
+type WindowCounter struct {
+	counts  [counterBuckets]int
+	minutes [counterBuckets]int64 // Minute index (unix minutes) of each bucket
+	mu      sync.Mutex
+	now     func() time.Time // Clock for tests, time.Now if nil
+}
+
+func (c *WindowCounter) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func bucketMinute(t time.Time) int64 {
+	return t.Unix() / int64(counterBucketWidth/time.Second)
+}
+
+// Add n events at time t in O(1)
+func (c *WindowCounter) Add(t time.Time, n int) {
+	minute := bucketMinute(t)
+	if minute <= bucketMinute(c.clock())-counterBuckets {
+		return // Older than the counter span
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := minute % counterBuckets
+	if c.minutes[i] != minute {
+		c.minutes[i] = minute
+		c.counts[i] = 0
+	}
+	c.counts[i] += n
+}
+
+// Count returns the events of the last window at one-minute resolution: the
+// window is rounded up to whole minutes, the current minute included, so it
+// never counts more than the rounded window. Windows longer than 24 hours
+// are capped.
+func (c *WindowCounter) Count(window time.Duration) int {
+	if window <= 0 {
+		return 0
+	}
+	buckets := int64((window + counterBucketWidth - 1) / counterBucketWidth)
+	if buckets > counterBuckets {
+		buckets = counterBuckets
+	}
+	now := bucketMinute(c.clock())
+	first := now - buckets + 1
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := 0
+	for minute := first; minute <= now; minute++ {
+		i := minute % counterBuckets
+		if c.minutes[i] == minute {
+			total += c.counts[i]
+		}
+	}
+	return total
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowCounter(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	now := start
+	var c WindowCounter
+	c.now = func() time.Time { return now }
+
+	c.Add(start.Add(10*time.Second), 1) // 12:00:10
+	c.Add(start.Add(50*time.Second), 2) // 12:00:50
+	now = start.Add(70 * time.Second)
+	c.Add(now, 4) // 12:01:10
+
+	tests := []struct {
+		at     time.Duration
+		window time.Duration
+		want   int
+	}{
+		// At 12:01:10 a minute is the current bucket only, not the one before
+		{70 * time.Second, time.Minute, 4},
+		{70 * time.Second, 30 * time.Second, 4},
+		{70 * time.Second, 90 * time.Second, 7},
+		{70 * time.Second, 2 * time.Minute, 7},
+		{70 * time.Second, 0, 0},
+		// The next bucket boundary moves everything out of a one-minute window
+		{2 * time.Minute, time.Minute, 0},
+		{2*time.Minute + 59*time.Second, 2 * time.Minute, 4},
+		{3 * time.Minute, 2 * time.Minute, 0},
+		{3 * time.Minute, 4 * time.Minute, 7},
+		// 24 hours is the full span, longer windows are capped
+		{24 * time.Hour, 24 * time.Hour, 4},
+		{24 * time.Hour, 48 * time.Hour, 4},
+		{24*time.Hour + time.Minute, 48 * time.Hour, 0},
+	}
+
+	for _, tt := range tests {
+		now = start.Add(tt.at)
+		if got := c.Count(tt.window); got != tt.want {
+			t.Errorf("Count(%v) at %v = %d, want %d", tt.window, now.Format("15:04:05"), got, tt.want)
+		}
+	}
+}
+
+func TestWindowCounterReuse(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	now := start
+	var c WindowCounter
+	c.now = func() time.Time { return now }
+
+	c.Add(now, 3)
+	now = start.Add(24 * time.Hour) // Same bucket slot a day later
+	c.Add(now, 1)
+	c.Add(start, 5) // Older than the span, ignored
+	if got := c.Count(24 * time.Hour); got != 1 {
+		t.Errorf("Count after a day = %d, want 1", got)
+	}
+}
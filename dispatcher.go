@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Retry settings for a single channel delivery
+const (
+	notifyMaxAttempts  = 5
+	notifyRetryBackoff = time.Second
+	notifyMaxBackoff   = time.Minute
+	notifyGiveUpPause  = 10 * time.Minute // Wait after notifyMaxAttempts failures before trying again
+	notifyMaxAge       = time.Hour        // Undelivered notifications of any event are dropped after this
+)
+
+// Dedicated client so slow notification backends don't share the upstream client
+var notifyClient = &http.Client{
+	Timeout: notifyTimeout,
+}
+
+// One notification for one channel
+type notifyJob struct {
+	id       uint64
+	channel  *notifierChannel
+	n        Notification
+	queued   time.Time
+	attempts int
+}
+
+// Dispatcher delivers notifications in the background so alerts never delay
+// the checks that produced them
+type Dispatcher struct {
+	jobs    chan *notifyJob
+	mu      sync.Mutex
+	pending map[uint64]time.Time // Queued and retrying jobs by ID
+	nextID  uint64
+	dropped int
+	parked  int     // Pending jobs waiting out notifyGiveUpPause
+	outbox  *Outbox // Optional journal so undelivered jobs survive restarts
+
+	maxStockAge time.Duration // Stock alerts older than this are dropped instead of retried
+}
+
+// QueueStats is the dispatcher view exposed in /status
+type QueueStats struct {
+	Depth            int     `json:"depth"`
+	Capacity         int     `json:"capacity"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	Dropped          int     `json:"dropped"`
+}
+
+var dispatcher *Dispatcher
+
+// Start a dispatcher with the given backlog size and number of workers
+func newDispatcher(size, workers int) *Dispatcher {
+	d := &Dispatcher{
+		jobs:    make(chan *notifyJob, size),
+		pending: make(map[uint64]time.Time),
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return d
+}
+
+// Default age after which undelivered stock alerts are dropped, in milliseconds
+const DEFAULT_OUTBOX_MAX_STOCK_AGE = "600000"
+
+// Read NOTIFY_QUEUE_SIZE and NOTIFY_WORKERS with defaults, then replay the
+// outbox in DATA_DIR
+func loadDispatcher() (*Dispatcher, error) {
+	size := envInt("NOTIFY_QUEUE_SIZE", 100)
+	workers := envInt("NOTIFY_WORKERS", 2)
+	log.Printf("- NOTIFY_QUEUE_SIZE: %d, NOTIFY_WORKERS: %d", size, workers)
+
+	maxAgeValue := setting("OUTBOX_MAX_STOCK_AGE")
+	if maxAgeValue == "" {
+		maxAgeValue = DEFAULT_OUTBOX_MAX_STOCK_AGE
+	}
+	maxStockAge, err := parseInterval(maxAgeValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outbox max stock age: %v", err)
+	}
+
+	outbox, pending, err := openOutbox(dataDir())
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDispatcher(size, workers)
+	d.outbox = outbox
+	d.maxStockAge = maxStockAge
+	d.replay(pending, maxStockAge)
+	return d, nil
+}
+
+// Requeue notifications left over from a previous run
+func (d *Dispatcher) replay(pending []outboxRecord, maxStockAge time.Duration) {
+	channels := make(map[string]*notifierChannel)
+	for _, channel := range currentNotifiers() {
+		channels[channel.stats.Name] = channel
+	}
+
+	replayed, dropped := 0, 0
+	for _, record := range pending {
+		d.mu.Lock()
+		if record.ID > d.nextID {
+			d.nextID = record.ID
+		}
+		d.mu.Unlock()
+
+		channel, ok := channels[record.Channel]
+		age := time.Since(record.Queued)
+		stale := age > notifyMaxAge || isStockEvent(record.Notification.Event) && age > maxStockAge
+		if !ok || stale {
+			d.outbox.Done(record.ID)
+			dropped++
+			continue
+		}
+
+		job := &notifyJob{id: record.ID, channel: channel, n: *record.Notification, queued: record.Queued}
+		d.mu.Lock()
+		d.pending[job.id] = job.queued
+		d.mu.Unlock()
+		if d.push(job) {
+			replayed++
+		}
+	}
+
+	if replayed > 0 || dropped > 0 {
+		log.Printf("Outbox replay: %d notification(s) requeued, %d stale or unknown dropped", replayed, dropped)
+	}
+}
+
+// Read a positive integer setting
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(setting(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Enqueue queues n for delivery on channel, failing if the backlog is full
+func (d *Dispatcher) Enqueue(channel *notifierChannel, n Notification) error {
+	d.mu.Lock()
+	d.nextID++
+	job := &notifyJob{id: d.nextID, channel: channel, n: n, queued: time.Now()}
+	d.pending[job.id] = job.queued
+	d.mu.Unlock()
+
+	// Journal before queueing so a crash can't lose the notification
+	if d.outbox != nil {
+		if err := d.outbox.Add(job); err != nil {
+			log.Printf("Failed to journal notification: %v", err)
+		}
+	}
+
+	if !d.push(job) {
+		return fmt.Errorf("notification queue full, dropped %q for %s", n.Title, channel.stats.Name)
+	}
+	return nil
+}
+
+// Non-blocking send to the queue. When full the job is dropped and closed
+// in the outbox, so it isn't replayed after a restart either.
+func (d *Dispatcher) push(job *notifyJob) bool {
+	select {
+	case d.jobs <- job:
+		return true
+	default:
+		d.mu.Lock()
+		d.dropped++
+		d.mu.Unlock()
+		d.done(job)
+		return false
+	}
+}
+
+func (d *Dispatcher) worker() {
+	for job := range d.jobs {
+		d.deliver(job)
+	}
+}
+
+// Send job once and schedule a retry with exponential backoff on failure
+func (d *Dispatcher) deliver(job *notifyJob) {
+	job.attempts++
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	err := job.channel.backend().Send(ctx, job.n)
+	cancel()
+
+	job.channel.record(err)
+	if err == nil {
+		metrics.incrementNotifications()
+		store.Record(StoredEvent{
+			Type:    StoredNotification,
+			Target:  job.n.Target,
+			Message: job.n.Title,
+			Details: map[string]string{"channel": job.channel.stats.Name, "event": job.n.Event},
+		})
+		publishEvent(SSENotificationSent, job.n.Target, NotificationSentEvent{
+			Channel: job.channel.stats.Name,
+			Event:   job.n.Event,
+			Title:   job.n.Title,
+			Target:  job.n.Target,
+			Time:    time.Now(),
+		})
+		d.done(job)
+		return
+	}
+
+	name := job.channel.stats.Name
+	if job.attempts >= notifyMaxAttempts {
+		// Keep the job, a channel that is down for a while gets it later
+		log.Printf("Giving up on %s notification %q after %d attempts, trying again in %v: %v",
+			name, job.n.Title, job.attempts, notifyGiveUpPause, err)
+		job.attempts = 0
+		d.mu.Lock()
+		d.parked++
+		d.mu.Unlock()
+		time.AfterFunc(notifyGiveUpPause, func() {
+			d.mu.Lock()
+			d.parked--
+			d.mu.Unlock()
+			d.retry(job)
+		})
+		return
+	}
+
+	backoff := notifyRetryBackoff << (job.attempts - 1)
+	if backoff > notifyMaxBackoff {
+		backoff = notifyMaxBackoff
+	}
+	log.Printf("Failed to send %s notification %q (attempt %d), retrying in %v: %v",
+		name, job.n.Title, job.attempts, backoff, err)
+
+	time.AfterFunc(backoff, func() { d.retry(job) })
+}
+
+// Requeue a failed job, unless its channel was removed by a config reload
+// or it is too old to act on
+func (d *Dispatcher) retry(job *notifyJob) {
+	name := job.channel.stats.Name
+	active := false
+	for _, channel := range currentNotifiers() {
+		active = active || channel == job.channel
+	}
+	switch {
+	case !active:
+		log.Printf("Dropped %q, channel %s is no longer configured", job.n.Title, name)
+		d.done(job)
+	case time.Since(job.queued) > notifyMaxAge:
+		log.Printf("Dropped %s notification %q, undelivered for %v", name, job.n.Title, notifyMaxAge)
+		d.done(job)
+	case d.maxStockAge > 0 && isStockEvent(job.n.Event) && time.Since(job.queued) > d.maxStockAge:
+		log.Printf("Dropped stale %s notification %q", name, job.n.Title)
+		d.done(job)
+	case !d.push(job):
+		log.Printf("Notification queue full, dropped retry of %q for %s", job.n.Title, name)
+	}
+}
+
+// Remove a delivered or dropped job from the backlog and the outbox
+func (d *Dispatcher) done(job *notifyJob) {
+	d.mu.Lock()
+	delete(d.pending, job.id)
+	d.mu.Unlock()
+
+	if d.outbox != nil {
+		if err := d.outbox.Done(job.id); err != nil {
+			log.Printf("Failed to update outbox: %v", err)
+		}
+	}
+}
+
+// Number of pending jobs that are queued or retrying soon
+func (d *Dispatcher) active() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending) - d.parked
+}
+
+// Stats returns the current backlog, including jobs waiting for a retry
+func (d *Dispatcher) Stats() QueueStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := QueueStats{
+		Depth:    len(d.pending),
+		Capacity: cap(d.jobs),
+		Dropped:  d.dropped,
+	}
+	now := time.Now()
+	for _, queued := range d.pending {
+		if age := now.Sub(queued).Seconds(); age > stats.OldestAgeSeconds {
+			stats.OldestAgeSeconds = age
+		}
+	}
+	return stats
+}
+
+// Drain waits until the backlog is empty or ctx is done. Jobs waiting out
+// notifyGiveUpPause stay in the outbox for the next start instead.
+func (d *Dispatcher) Drain(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for d.active() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutting down with %d undelivered notification(s)", d.Stats().Depth)
+			return
+		case <-ticker.C:
+		}
+	}
+}
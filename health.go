@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Health check settings
+const (
+	DEFAULT_READY_MAX_MISSED_CHECKS = 5 // Polling intervals without a successful upstream request
+	healthProbeTimeout              = 3 * time.Second
+	healthProbeCacheTTL             = 30 * time.Second // Notifier probes are reused for this long
+	healthCheckURL                  = "http://localhost:8080/readyz"
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is the /healthz and /readyz payload
+type HealthReport struct {
+	Status string        `json:"status"` // ok or fail
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// Cached notifier reachability, so probes don't dial out on every request
+var (
+	notifierProbes   []HealthCheck
+	notifierProbedAt time.Time
+	notifierProbesMu sync.Mutex
+)
+
+// Handle GET /healthz: the server is up and serving
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, HealthReport{Status: "ok"})
+}
+
+// Handle GET /readyz: upstream checks are succeeding and every notifier is
+// reachable
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := append([]HealthCheck{checkUpstream()}, checkNotifiers(r.Context())...)
+
+	report := HealthReport{Status: "ok", Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "fail"
+		}
+	}
+	writeHealthReport(w, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// The last successful upstream request must be at most
+// READY_MAX_MISSED_CHECKS polling intervals old. Until a SKU is known only
+// the catalog search reaches upstream, so the longer of the stock and SKU
+// intervals counts, multiplied by the backoff factor. Right after startup
+// the same window counts from the start time. Retry-After pauses don't
+// stretch the window, so an upstream that keeps blocking us fails the check.
+func checkUpstream() HealthCheck {
+	check := HealthCheck{Name: "upstream"}
+	if scheduler == nil {
+		check.Error = "monitoring not started"
+		return check
+	}
+
+	_, intervals := scheduler.snapshot()
+	interval := intervals.Stock
+	if intervals.Sku > interval {
+		interval = intervals.Sku
+	}
+	interval *= time.Duration(upstreamBackoff.Factor())
+	maxAge := time.Duration(envInt("READY_MAX_MISSED_CHECKS", DEFAULT_READY_MAX_MISSED_CHECKS)) * interval
+
+	metrics.mu.Lock()
+	lastSuccess := metrics.LastSuccess
+	startTime := metrics.StartTime
+	metrics.mu.Unlock()
+
+	since, what := lastSuccess, "last successful upstream request %v ago"
+	if since.IsZero() {
+		since, what = startTime, "no successful upstream request since start %v ago"
+	}
+	if age := time.Since(since); age > maxAge {
+		check.Error = fmt.Sprintf(what, age.Round(time.Second))
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// Dial every notifier backend, reusing results younger than the cache TTL
+func checkNotifiers(ctx context.Context) []HealthCheck {
+	notifierProbesMu.Lock()
+	defer notifierProbesMu.Unlock()
+
+	if notifierProbes != nil && time.Since(notifierProbedAt) < healthProbeCacheTTL {
+		return notifierProbes
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	channels := currentNotifiers()
+	checks := make([]HealthCheck, len(channels))
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			checks[i] = HealthCheck{Name: "notifier:" + n.Name(), OK: true}
+			addr := n.Addr()
+			if _, smtp := n.(*emailNotifier); notifyProxyAddr != "" && !smtp {
+				addr = notifyProxyAddr
+			}
+			if err := probeAddr(ctx, addr); err != nil {
+				checks[i].OK = false
+				checks[i].Error = err.Error()
+			}
+		}(i, channel.backend())
+	}
+	wg.Wait()
+
+	notifierProbes = checks
+	notifierProbedAt = time.Now()
+	return checks
+}
+
+// Open and close a TCP connection to addr
+func probeAddr(ctx context.Context, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("no address configured")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// host:port of a URL, with the default port of its scheme
+func urlAddr(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "443"
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Probe /readyz of the running server, used by the Docker HEALTHCHECK
+func performHealthCheck() bool {
+	probeClient := &http.Client{Timeout: healthProbeTimeout + time.Second}
+	resp, err := probeClient.Get(healthCheckURL)
+	if err != nil {
+		log.Printf("Health check failed: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return true
+	}
+	var report HealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		log.Printf("Health check failed: status %d", resp.StatusCode)
+		return false
+	}
+	for _, check := range report.Checks {
+		if !check.OK {
+			log.Printf("Health check failed: %s: %s", check.Name, check.Error)
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size limits for the JSON history
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// HistoryFilter selects events from the store
+type HistoryFilter struct {
+	Targets map[string]bool
+	Types   map[string]bool
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f HistoryFilter) match(event StoredEvent) bool {
+	if len(f.Targets) > 0 && !f.Targets[event.Target] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[event.Type] {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the events matching f in chronological order
+func queryHistory(f HistoryFilter) []StoredEvent {
+	events := store.Since("", f.Since)
+	matched := events[:0]
+	for _, event := range events {
+		if f.match(event) {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+// Parse a time given as RFC 3339, unix seconds or a duration ago like "24h"
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339, unix seconds or a duration like 24h", value)
+}
+
+// Split comma separated values, possibly repeated, into a set
+func parseSetParam(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				set[item] = true
+			}
+		}
+	}
+	return set
+}
+
+func parseHistoryFilter(r *http.Request) (HistoryFilter, error) {
+	query := r.URL.Query()
+	filter := HistoryFilter{
+		Targets: parseSetParam(query["target"]),
+		Types:   parseSetParam(query["type"]),
+	}
+
+	var err error
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = parseTimeParam(value); err != nil {
+			return filter, err
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = parseTimeParam(value); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// Handle GET /api/history with target, type, since, until, limit, offset and format
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "jsonl" {
+		http.Error(w, "format must be json, csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	// Downloads export everything unless a limit is given
+	limit := 0
+	if format == "json" {
+		limit = defaultHistoryLimit
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if format == "json" && limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events := queryHistory(filter)
+	total := len(events)
+	if offset > total {
+		offset = total
+	}
+	page := events[offset:]
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="fe-tracker-history.csv"`)
+		writeHistoryCSV(w, page)
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="fe-tracker-history.jsonl"`)
+		enc := json.NewEncoder(w)
+		for _, event := range page {
+			enc.Encode(event)
+		}
+	default:
+		if page == nil {
+			page = []StoredEvent{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Total  int           `json:"total"`
+			Limit  int           `json:"limit"`
+			Offset int           `json:"offset"`
+			Events []StoredEvent `json:"events"`
+		}{total, limit, offset, page})
+	}
+}
+
+func writeHistoryCSV(w http.ResponseWriter, events []StoredEvent) {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "time", "type", "target", "sku", "message", "count", "details"})
+	for _, event := range events {
+		writer.Write([]string{
+			strconv.FormatUint(event.ID, 10),
+			event.Time.Format(time.RFC3339),
+			event.Type,
+			event.Target,
+			event.SKU,
+			event.Message,
+			strconv.Itoa(event.Count),
+			formatDetails(event.Details),
+		})
+	}
+	writer.Flush()
+}
+
+// Flatten details to sorted key=value pairs for CSV
+func formatDetails(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+details[key])
+	}
+	return strings.Join(pairs, ";")
+}
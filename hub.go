@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Hub timings, per-client backlog and replay buffer size
+const (
+	hubStatusInterval = time.Second
+	hubPingInterval   = 10 * time.Second
+	hubClientBuffer   = 16
+	hubReplaySize     = 256
+	hubRetryHint      = 3 * time.Second // Reconnect delay suggested to browsers
+)
+
+// Named events sent on /events and /ws
+const (
+	SSEStatus           = "status"
+	SSEStockIn          = "stock_in"
+	SSEStockOut         = "stock_out"
+	SSESKUChanged       = "sku_changed"
+	SSEPriceChanged     = "price_changed"
+	SSEErrorThreshold   = "error_threshold"
+	SSENotificationSent = "notification_sent"
+)
+
+// Event is a typed message pushed to /events and /ws clients. Events with a
+// target only reach clients subscribed to it.
+type Event struct {
+	Type   string
+	Target string
+	Data   interface{}
+}
+
+// Payloads of the typed events
+type StockEvent struct {
+	Target          string    `json:"target"`
+	SKU             string    `json:"sku"`
+	PurchaseURL     string    `json:"purchase_url,omitempty"`
+	Price           float64   `json:"price,omitempty"`
+	Currency        string    `json:"currency,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"` // How long the stock lasted, on stock_out
+	Time            time.Time `json:"time"`
+}
+
+type ErrorThresholdEvent struct {
+	Errors    int       `json:"errors_last_minute"`
+	LastError string    `json:"last_error"`
+	Time      time.Time `json:"time"`
+}
+
+type NotificationSentEvent struct {
+	Channel string    `json:"channel"`
+	Event   string    `json:"event"`
+	Title   string    `json:"title"`
+	Target  string    `json:"target,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// An event encoded once for every transport. Frames without an ID are pings.
+type hubFrame struct {
+	id     uint64
+	target string
+	status bool
+	sse    []byte // SSE frame
+	ws     []byte // WebSocket text message
+}
+
+var hubPing = hubFrame{sse: []byte(": ping\n\n")}
+
+// WebSocket message carrying an event
+type wsEvent struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// A connected client, targets is nil when it follows every target
+type hubClient struct {
+	frames  chan hubFrame
+	targets map[string]bool
+}
+
+// Hub builds each status snapshot once and fans the encoded frames out to
+// every /events and /ws client. Clients that fall a full buffer behind are
+// dropped. Recent typed events are kept so reconnecting clients can resume
+// from their Last-Event-ID; status frames only replace the latest status,
+// so frequent status changes don't push events out of the buffer.
+type Hub struct {
+	clients      map[uint64]*hubClient
+	nextClientID uint64
+	lastEventID  uint64
+	history      []hubFrame // Last hubReplaySize typed events, ordered by ID
+	evicted      uint64     // ID of the newest event no longer in history
+	dropped      int
+	status       []byte   // Last status payload, nil while nobody listens
+	statusFrame  hubFrame // Frame of the last status payload
+	mu           sync.Mutex
+}
+
+var hub = newHub()
+
+// Event IDs continue from the start time in milliseconds, so IDs of an
+// earlier run are lower and can't skip events of this one
+func newHub() *Hub {
+	start := uint64(time.Now().UnixMilli())
+	return &Hub{clients: make(map[uint64]*hubClient), lastEventID: start, evicted: start}
+}
+
+// Subscribe registers a client and returns its ID and frame channel, which
+// is closed when the client is dropped. With resume set, the events after
+// lastEventID are replayed if they are still buffered, otherwise the client
+// only gets the current status.
+func (h *Hub) Subscribe(lastEventID uint64, resume bool) (uint64, <-chan hubFrame) {
+	h.mu.Lock()
+	var replay []hubFrame
+	if resume && h.canResume(lastEventID) {
+		for _, frame := range h.history {
+			if frame.id > lastEventID {
+				replay = append(replay, frame)
+			}
+		}
+		// The latest status goes in ID order, so the client's last ID
+		// never moves backwards
+		if h.status != nil && h.statusFrame.id > lastEventID {
+			i := len(replay)
+			for i > 0 && replay[i-1].id > h.statusFrame.id {
+				i--
+			}
+			replay = append(replay[:i], append([]hubFrame{h.statusFrame}, replay[i:]...)...)
+		}
+	} else if h.status != nil {
+		replay = append(replay, h.statusFrame)
+	}
+
+	h.nextClientID++
+	id := h.nextClientID
+	client := &hubClient{frames: make(chan hubFrame, hubClientBuffer+len(replay))}
+	for _, frame := range replay {
+		client.frames <- frame
+	}
+	h.clients[id] = client
+	cached := h.status != nil
+	h.mu.Unlock()
+
+	// No snapshot is kept while nobody listens, so build one now
+	if !cached {
+		h.refreshStatus()
+	}
+	return id, client.frames
+}
+
+// Follow limits the targeted events a client gets, nil follows every target
+func (h *Hub) Follow(id uint64, targets map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client, ok := h.clients[id]; ok {
+		client.targets = targets
+	}
+}
+
+// Whether every event after lastEventID is still in the replay buffer. IDs
+// from an earlier run are below the first ID of this one.
+func (h *Hub) canResume(lastEventID uint64) bool {
+	return lastEventID >= h.evicted && lastEventID <= h.lastEventID
+}
+
+// Unsubscribe removes a client, safe to call after it was dropped
+func (h *Hub) Unsubscribe(id uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client, ok := h.clients[id]; ok {
+		delete(h.clients, id)
+		close(client.frames)
+	}
+}
+
+// Stats returns the number of connected clients and of dropped slow clients
+func (h *Hub) Stats() (clients, dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients), h.dropped
+}
+
+// Publish sends a named event to every client
+func (h *Hub) Publish(event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publishLocked(event.Type, event.Target, data)
+}
+
+// Assign the next ID to an event, buffer it for replay and send it
+func (h *Hub) publishLocked(eventType, target string, data []byte) {
+	h.lastEventID++
+	ws, err := json.Marshal(wsEvent{ID: h.lastEventID, Type: eventType, Data: data})
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
+	}
+	frame := hubFrame{
+		id:     h.lastEventID,
+		target: target,
+		status: eventType == SSEStatus,
+		sse:    []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", h.lastEventID, eventType, data)),
+		ws:     ws,
+	}
+	if frame.status {
+		h.status, h.statusFrame = data, frame
+	} else {
+		h.history = append(h.history, frame)
+		if len(h.history) > hubReplaySize {
+			h.evicted = h.history[len(h.history)-hubReplaySize-1].id
+			h.history = append([]hubFrame(nil), h.history[len(h.history)-hubReplaySize:]...)
+		}
+	}
+	h.broadcastLocked(frame)
+}
+
+func (h *Hub) broadcastLocked(frame hubFrame) {
+	for id, client := range h.clients {
+		if frame.target != "" && client.targets != nil && !client.targets[frame.target] {
+			continue
+		}
+		select {
+		case client.frames <- frame:
+		default:
+			// Too slow to keep up, the client reconnects and resumes
+			delete(h.clients, id)
+			close(client.frames)
+			h.dropped++
+			log.Printf("Dropped slow client %d", id)
+		}
+	}
+}
+
+// Run broadcasts the status whenever it changes and keeps connections alive
+// with pings until ctx is done
+func (h *Hub) Run(ctx context.Context) {
+	statusTicker := time.NewTicker(hubStatusInterval)
+	pingTicker := time.NewTicker(hubPingInterval)
+	defer statusTicker.Stop()
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.closeAll()
+			return
+		case <-pingTicker.C:
+			h.mu.Lock()
+			h.broadcastLocked(hubPing)
+			h.mu.Unlock()
+		case <-statusTicker.C:
+			h.refreshStatus()
+		}
+	}
+}
+
+// Build one snapshot and send it only if it differs from the last one
+func (h *Hub) refreshStatus() {
+	if clients, _ := h.Stats(); clients == 0 {
+		h.mu.Lock()
+		h.status, h.statusFrame = nil, hubFrame{}
+		h.mu.Unlock()
+		return
+	}
+
+	data, err := json.Marshal(buildStatusSnapshot())
+	if err != nil {
+		log.Printf("Failed to encode status: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !bytes.Equal(data, h.status) {
+		h.publishLocked(SSEStatus, "", data)
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, client := range h.clients {
+		delete(h.clients, id)
+		close(client.frames)
+	}
+}
+
+// Send an event about target, or about no target if empty, to every client
+func publishEvent(eventType, target string, data interface{}) {
+	hub.Publish(Event{Type: eventType, Target: target, Data: data})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+// Hub with a status and n typed events, returns the event IDs in order
+func testHub(n int) (*Hub, []uint64) {
+	h := newHub()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.publishLocked(SSEStatus, "", []byte(`{"status":"running"}`))
+	ids := make([]uint64, n)
+	for i := range ids {
+		h.publishLocked(SSEStockIn, "de-de/5090", []byte(fmt.Sprintf(`{"n":%d}`, i)))
+		ids[i] = h.lastEventID
+	}
+	return h, ids
+}
+
+// IDs of the frames queued for a new client
+func subscribeIDs(h *Hub, lastEventID uint64, resume bool) []uint64 {
+	id, frames := h.Subscribe(lastEventID, resume)
+	defer h.Unsubscribe(id)
+	var ids []uint64
+	for len(frames) > 0 {
+		ids = append(ids, (<-frames).id)
+	}
+	return ids
+}
+
+func TestHubResume(t *testing.T) {
+	h, events := testHub(5)
+	status := h.statusFrame.id
+	first := events[0]
+
+	tests := []struct {
+		name   string
+		lastID uint64
+		resume bool
+		want   []uint64
+	}{
+		{"fresh connect", 0, false, []uint64{status}},
+		{"resume from the last event", events[4], true, nil},
+		{"resume from a known event", events[2], true, events[3:]},
+		// The status was published before every event, so it comes first
+		{"resume before the status", status - 1, true, append([]uint64{status}, events...)},
+		{"resume from the status", status, true, events},
+		{"ID from an earlier run", first - 1000, true, []uint64{status}},
+		{"ID from the future", events[4] + 1, true, []uint64{status}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subscribeIDs(h, tt.lastID, tt.resume); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubResumeStatusOrder(t *testing.T) {
+	h, events := testHub(3)
+	h.mu.Lock()
+	h.publishLocked(SSEStatus, "", []byte(`{"status":"degraded"}`))
+	status := h.statusFrame.id
+	h.publishLocked(SSEStockOut, "de-de/5090", []byte(`{}`))
+	last := h.lastEventID
+	h.mu.Unlock()
+
+	// Only the latest status is kept, in ID order between the events
+	want := []uint64{events[1], events[2], status, last}
+	if got := subscribeIDs(h, events[0], true); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestHubResumeEvicted(t *testing.T) {
+	h, events := testHub(hubReplaySize + 10)
+	status := h.statusFrame.id
+	oldest := events[10]
+
+	if len(h.history) != hubReplaySize || h.history[0].id != oldest {
+		t.Fatalf("history holds %d events from %d, want %d from %d", len(h.history), h.history[0].id, hubReplaySize, oldest)
+	}
+
+	// Events after the evicted ID are all still buffered
+	if got := subscribeIDs(h, events[9], true); len(got) != hubReplaySize || got[0] != oldest {
+		t.Errorf("resume from the newest evicted event replayed %d from %v", len(got), got[:1])
+	}
+	// Older IDs missed evicted events and get a full resync instead
+	for _, lastID := range []uint64{events[8], events[0], status} {
+		if got := subscribeIDs(h, lastID, true); fmt.Sprint(got) != fmt.Sprint([]uint64{status}) {
+			t.Errorf("resume from evicted %d replayed %d frames, want only the status", lastID, len(got))
+		}
+	}
+}
+
+func TestParseLastEventID(t *testing.T) {
+	tests := []struct {
+		header string
+		query  string
+		id     uint64
+		resume bool
+	}{
+		{"", "", 0, false},
+		{"42", "", 42, true},
+		{"", "42", 42, true},
+		{"42", "7", 42, true},
+		{"0", "", 0, true},
+		{"abc", "", 0, false},
+		{"-1", "", 0, false},
+		{" 42", "", 0, false},
+		{"4.2", "", 0, false},
+		{"18446744073709551616", "", 0, false}, // Overflows uint64
+		{"", "x", 0, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/events?last_event_id="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Last-Event-ID", tt.header)
+		}
+		id, resume := parseLastEventID(r)
+		if resume != tt.resume || (resume && id != tt.id) {
+			t.Errorf("header %q, query %q = %d, %v; want %d, %v", tt.header, tt.query, id, resume, tt.id, tt.resume)
+		}
+	}
+}
@@ -7,16 +7,14 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
-	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -24,20 +22,32 @@ import (
 // Add response structure
 type NvidiaSearchResponse struct {
 	SearchedProducts struct {
-		ProductDetails []struct {
-			DisplayName      string `json:"displayName"`
-			IsFounderEdition bool   `json:"isFounderEdition"`
-			ProductSKU       string `json:"productSKU"`
-		} `json:"productDetails"`
+		ProductDetails []SearchProduct `json:"productDetails"`
 	} `json:"searchedProducts"`
 }
 
-// Add new type for inventory response
+type SearchProduct struct {
+	DisplayName      string      `json:"displayName"`
+	IsFounderEdition bool        `json:"isFounderEdition"`
+	ProductSKU       string      `json:"productSKU"`
+	ProductID        json.Number `json:"productID"`
+	ProductPrice     string      `json:"productPrice"` // Formatted, e.g. "€2,329.00"
+	ImageURL         string      `json:"imageURL"`
+	Retailers        []struct {
+		RetailerName string `json:"retailerName"`
+	} `json:"retailers"`
+}
+
+// Add new type for inventory response, one listing per requested SKU
 type InventoryResponse struct {
-	ListMap []struct {
-		IsActive   string `json:"is_active"`
-		ProductURL string `json:"product_url"`
-	} `json:"listMap"`
+	ListMap []InventoryItem `json:"listMap"`
+}
+
+type InventoryItem struct {
+	IsActive   string `json:"is_active"`
+	ProductURL string `json:"product_url"`
+	FeSKU      string `json:"fe_sku"`
+	Price      string `json:"price"` // e.g. "2329.00"
 }
 
 // Add HTTP client with timeout
@@ -52,50 +62,45 @@ type Error struct {
 }
 
 type ErrorTracking struct {
-	Errors          []Error
+	Errors          []Error // Most recent errors, at most maxErrors
 	LastNotify      time.Time
 	Threshold       int
 	Window          time.Duration
 	mu              sync.Mutex
-	lastErrorNotify time.Time // Add new field for error message cooldown
-	maxErrors       int       // Add maximum number of errors to store
+	lastErrorNotify time.Time     // Add new field for error message cooldown
+	maxErrors       int           // Add maximum number of errors to store
+	counts          WindowCounter // Per-minute error counts for the last 24h
 }
 
 var errorTracker = ErrorTracking{
-	Threshold: 3,           // Notify after 3 errors
-	Window:    time.Minute, // Within 1 minute
-	maxErrors: 1000,        // Limit error history
+	Threshold: DEFAULT_ERROR_THRESHOLD, // Notify after 3 errors
+	Window:    time.Minute,             // Within 1 minute
+	maxErrors: 1000,                    // Limit error history
 }
 
 // Add method to get 24h error count
 func (et *ErrorTracking) get24hErrorCount() int {
-	et.mu.Lock()
-	defer et.mu.Unlock()
-
-	count := 0
-	now := time.Now()
-	dayAgo := now.Add(-24 * time.Hour)
+	return et.counts.Count(24 * time.Hour)
+}
 
-	// Only count errors from last 24 hours
-	for _, err := range et.Errors {
-		if err.Timestamp.After(dayAgo) {
-			count++
-		}
+// Keep err in the bounded recent error list and the counter
+func (et *ErrorTracking) record(e Error) {
+	et.counts.Add(e.Timestamp, 1)
+	et.Errors = append(et.Errors, e)
+	if len(et.Errors) > et.maxErrors {
+		et.Errors = append([]Error(nil), et.Errors[len(et.Errors)-et.maxErrors:]...)
 	}
-
-	return count
 }
 
 // Add new types for status tracking
 type Metrics struct {
-	CurrentSKU      string      `json:"current_sku"`
-	ErrorCount      int         `json:"error_count"`
-	ApiRequests     int         `json:"api_requests_24h"`
-	NtfySent        int         `json:"ntfy_messages_sent"`
-	StartTime       time.Time   `json:"start_time"`
-	LastStatusCheck time.Time   `json:"last_status_check"`
-	PurchaseURL     string      `json:"purchase_url"`
-	ApiRequestTimes []time.Time // Add this field
+	ErrorCount      int           `json:"error_count"` // Since start, the 24h count is in errorTracker
+	StartTime       time.Time     `json:"start_time"`
+	LastStatusCheck time.Time     `json:"last_status_check"`
+	LastSuccess     time.Time     `json:"last_success"` // Last upstream request answered with 200
+	apiRequests     WindowCounter // Per-minute request counts for the last 24h
+	notifications   WindowCounter // Per-minute delivered notifications for the last 24h
+	requestsRollup  int           // Requests since the last store rollup
 	mu              sync.Mutex
 }
 
@@ -103,7 +108,6 @@ type Metrics struct {
 var metrics = Metrics{
 	StartTime:       time.Now(),
 	LastStatusCheck: time.Now(),
-	ApiRequestTimes: make([]time.Time, 0),
 }
 
 func (m *Metrics) incrementApiRequests() {
@@ -111,45 +115,34 @@ func (m *Metrics) incrementApiRequests() {
 	defer m.mu.Unlock()
 
 	now := time.Now()
-
-	// Remove requests older than 24 hours
-	recent := make([]time.Time, 0)
-	for _, t := range m.ApiRequestTimes {
-		if now.Sub(t) <= 24*time.Hour {
-			recent = append(recent, t)
-		}
-	}
-
-	// Add new request
-	recent = append(recent, now)
-	m.ApiRequestTimes = recent
-	m.ApiRequests = len(recent)
+	m.apiRequests.Add(now, 1)
 	m.LastStatusCheck = now
+	m.requestsRollup++
 }
 
-func (m *Metrics) incrementErrors() {
+// Return and reset the number of requests since the last call
+func (m *Metrics) takeRequestRollup() int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.ErrorCount++
+	count := m.requestsRollup
+	m.requestsRollup = 0
+	return count
 }
 
-func (m *Metrics) incrementNtfy() {
+func (m *Metrics) incrementErrors() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.NtfySent++
+	m.ErrorCount++
 }
 
-func (m *Metrics) updateSKU(sku string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.CurrentSKU = sku
+func (m *Metrics) incrementNotifications() {
+	m.notifications.Add(time.Now(), 1)
 }
 
-// Add method to update purchase URL
-func (m *Metrics) updatePurchaseURL(url string) {
+func (m *Metrics) updateLastSuccess() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.PurchaseURL = url
+	m.LastSuccess = time.Now()
 }
 
 // Simplify updateLastCheck
@@ -160,40 +153,30 @@ func (m *Metrics) updateLastCheck() {
 }
 
 // Update AddError method with cooldown
-func (et *ErrorTracking) AddError(err error) {
+func (et *ErrorTracking) AddError(target string, err error) {
 	metrics.incrementErrors()
+	errorsByClass.Inc(classifyError(err))
+	store.Record(StoredEvent{Type: StoredError, Target: target, Message: err.Error()})
 	et.mu.Lock()
 	defer et.mu.Unlock()
 
 	now := time.Now()
-	dayAgo := now.Add(-24 * time.Hour)
+	et.record(Error{Timestamp: now, Err: err})
 
-	// Keep only errors from last 24 hours
-	recent := make([]Error, 0)
-	for _, e := range et.Errors {
-		if e.Timestamp.After(dayAgo) {
-			recent = append(recent, e)
-		}
-	}
-
-	// Add new error
-	recent = append(recent, Error{Timestamp: now, Err: err})
-	et.Errors = recent
-
-	// Check notification threshold within last minute
-	recentCount := 0
-	minuteAgo := now.Add(-time.Minute)
-	for _, e := range recent {
-		if e.Timestamp.After(minuteAgo) {
-			recentCount++
-		}
-	}
+	// Check notification threshold within the error window
+	recentCount := et.counts.Count(et.Window)
 
 	if recentCount >= et.Threshold && now.Sub(et.LastNotify) > et.Window {
 		if now.Sub(et.lastErrorNotify) > time.Minute {
-			msg := fmt.Sprintf("High error rate detected!\nLast error: %v\nTotal errors in last minute: %d",
-				err, recentCount)
-			if err := sendNtfyNotification("Error Threshold Reached", msg, 4); err != nil {
+			publishEvent(SSEErrorThreshold, "", ErrorThresholdEvent{Errors: recentCount, LastError: err.Error(), Time: now})
+			msg := fmt.Sprintf("High error rate detected!\nLast error: %v\nTotal errors in last %v: %d",
+				err, et.Window, recentCount)
+			if err := sendNotification(Notification{
+				Title:    "Error Threshold Reached",
+				Body:     msg,
+				Priority: 4,
+				Event:    EventErrorThreshold,
+			}); err != nil {
 				log.Printf("Failed to send error notification: %v", err)
 			}
 			et.lastErrorNotify = now
@@ -202,255 +185,410 @@ func (et *ErrorTracking) AddError(err error) {
 	}
 }
 
-// Add global ntfy topic
-var ntfyTopic string
+// Default data directory for on-disk state
+const DEFAULT_DATA_DIR = "data"
 
-// Add daily report time constant
-const DAILY_REPORT_TIME = "09:00"
+// Directory for on-disk state, DATA_DIR or ./data
+func dataDir() string {
+	if dir := setting("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return DEFAULT_DATA_DIR
+}
 
 // Add template caching
 var templates = template.Must(template.ParseFiles("static/index.html"))
 
-// Update ntfy function to handle priorities
-func sendNtfyNotification(title, message string, priority int) error {
-	metrics.incrementNtfy()
-	ntfyURL := fmt.Sprintf("https://ntfy.sh/%s", ntfyTopic)
-	req, err := http.NewRequest("POST", ntfyURL, strings.NewReader(message))
-	if err != nil {
-		return fmt.Errorf("creating ntfy request: %v", err)
-	}
-
-	req.Header.Set("Title", title)
-	req.Header.Set("Priority", fmt.Sprintf("%d", priority))
-	req.Header.Set("Content-Type", "text/plain")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("sending ntfy: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ntfy returned status: %d", resp.StatusCode)
-	}
-	return nil
-}
-
-// Update makeRequest to accept context and timezone
-func makeRequest(ctx context.Context, url string) (*NvidiaSearchResponse, error) {
-	metrics.incrementApiRequests()
+// Search the catalog of target
+func makeRequest(ctx context.Context, target Target) (*NvidiaSearchResponse, error) {
 	metrics.updateLastCheck()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Add headers to mimic browser
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Cache-Control", "no-cache")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		errorTracker.AddError(err) // Track the error
-		return nil, fmt.Errorf("request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
 	var response NvidiaSearchResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %v", err)
+	if err := upstream.GetJSON(ctx, EndpointSearch, target.ApiURL, target, &response); err != nil {
+		return nil, err
 	}
-
 	return &response, nil
 }
 
 type Config struct {
-	Locale             string
-	GpuModel           string
-	StockCheckInterval string
-	SkuCheckInterval   string
-	ProductURL         string
-	ApiURL             string
+	Targets         []Target
+	Intervals       Intervals
+	DailyReportTime string        // HH:MM local time
+	ErrorThreshold  int           // Errors within ErrorWindow that trigger an alert
+	ErrorWindow     time.Duration // Minimum time between error alerts
 }
 
-// Remove timezone loading from loadEnvConfig
-func loadEnvConfig() (Config, error) {
-	log.Println("Loading configuration from environment...")
+// Defaults for optional settings, intervals in milliseconds
+const (
+	DEFAULT_STOCK_REALERT_INTERVAL = "300000"
+	DEFAULT_DAILY_REPORT_TIME      = "09:00"
+	DEFAULT_ERROR_THRESHOLD        = 3
+	DEFAULT_ERROR_WINDOW           = "60000"
+)
 
-	// Define required variables
-	envVars := map[string]string{
+// Build the config from the layered settings and validate it
+func loadConfig() (Config, error) {
+	log.Println("Loading configuration...")
+
+	// Define required settings
+	required := map[string]string{
 		"NVIDIA_PRODUCT_URL":   "",
 		"STOCK_CHECK_INTERVAL": "",
 		"SKU_CHECK_INTERVAL":   "",
-		"NTFY_TOPIC":           "",
 	}
 
-	missingVars := []string{}
-
-	// Check environment variables directly (no .env loading)
-	for key := range envVars {
-		if value := os.Getenv(key); value != "" {
-			envVars[key] = value
+	missing := []string{}
+	for key := range required {
+		if value := setting(key); value != "" {
+			required[key] = value
 			log.Printf("- %s: %s", key, value)
 		} else {
 			log.Printf("- %s: not set", key)
-			missingVars = append(missingVars, key)
+			missing = append(missing, key)
 		}
 	}
 
-	if len(missingVars) > 0 {
-		return Config{}, fmt.Errorf("missing required environment variables: %v", missingVars)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return Config{}, fmt.Errorf("missing required settings: %v", missing)
+	}
+
+	// NVIDIA_PRODUCT_URL may hold several comma separated product URLs
+	targets, err := parseTargets(required["NVIDIA_PRODUCT_URL"])
+	if err != nil {
+		return Config{}, err
 	}
 
-	// Parse URL for locale and GPU model
-	re := regexp.MustCompile(`/([a-z]{2}-[a-z]{2})/.*?rtx-(\d{4})`)
-	matches := re.FindStringSubmatch(strings.ToLower(envVars["NVIDIA_PRODUCT_URL"]))
-	if matches == nil {
-		return Config{}, fmt.Errorf("invalid URL format. Expected pattern: .../xx-xx/...rtx-XXXX")
+	// Optional settings, a re-alert interval of 0 disables re-alerts
+	realert := settingOr("STOCK_REALERT_INTERVAL", DEFAULT_STOCK_REALERT_INTERVAL)
+	reportTime := settingOr("DAILY_REPORT_TIME", DEFAULT_DAILY_REPORT_TIME)
+	errorWindow := settingOr("ERROR_WINDOW", DEFAULT_ERROR_WINDOW)
+	log.Printf("- STOCK_REALERT_INTERVAL: %s, DAILY_REPORT_TIME: %s", realert, reportTime)
+
+	config := Config{
+		Targets:         targets,
+		DailyReportTime: reportTime,
+		ErrorThreshold:  envInt("ERROR_THRESHOLD", DEFAULT_ERROR_THRESHOLD),
+	}
+	if _, err := time.Parse("15:04", reportTime); err != nil {
+		return Config{}, fmt.Errorf("invalid DAILY_REPORT_TIME %q, expected HH:MM", reportTime)
+	}
+	if config.ErrorWindow, err = parseInterval(errorWindow); err != nil {
+		return Config{}, fmt.Errorf("invalid ERROR_WINDOW: %v", err)
 	}
 
-	locale, gpuModel := matches[1], matches[2]
-	apiURL := fmt.Sprintf("https://api.nvidia.partners/edge/product/search?page=1&limit=12&locale=%s&gpu=RTX%%20%s",
-		locale, gpuModel)
+	for _, interval := range []struct {
+		key, value string
+		dst        *time.Duration
+	}{
+		{"STOCK_CHECK_INTERVAL", required["STOCK_CHECK_INTERVAL"], &config.Intervals.Stock},
+		{"SKU_CHECK_INTERVAL", required["SKU_CHECK_INTERVAL"], &config.Intervals.Sku},
+		{"STOCK_REALERT_INTERVAL", realert, &config.Intervals.Realert},
+	} {
+		if *interval.dst, err = parseInterval(interval.value); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %v", interval.key, err)
+		}
+	}
+	if config.Intervals.Stock <= 0 || config.Intervals.Sku <= 0 {
+		return Config{}, fmt.Errorf("check intervals must be positive")
+	}
+	return config, nil
+}
 
-	return Config{
-		Locale:             locale,
-		GpuModel:           gpuModel,
-		StockCheckInterval: envVars["STOCK_CHECK_INTERVAL"],
-		SkuCheckInterval:   envVars["SKU_CHECK_INTERVAL"],
-		ProductURL:         envVars["NVIDIA_PRODUCT_URL"],
-		ApiURL:             apiURL,
-	}, nil
+// Setting value or fallback if unset
+func settingOr(key, fallback string) string {
+	if value := setting(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// List targets one per line for notifications
+func formatTargets(targets []Target) string {
+	lines := make([]string, 0, len(targets))
+	for _, target := range targets {
+		lines = append(lines, fmt.Sprintf("- %s (%s): %s", target.Product, target.Locale, target.ProductURL))
+	}
+	return strings.Join(lines, "\n")
 }
 
 func sendStartupNotification(config Config) error {
-	startupMsg := fmt.Sprintf(`- Locale: %s
-- GPU Model: %s
-- Stock Check Interval: %s
-- SKU Check Interval: %s
-- Product URL: %s`,
-		config.Locale,
-		config.GpuModel,
-		config.StockCheckInterval,
-		config.SkuCheckInterval,
-		config.ProductURL)
-
-	return sendNtfyNotification(
-		"FE Tracker Started",
-		startupMsg,
-		3,
-	)
+	startupMsg := fmt.Sprintf(`- Stock Check Interval: %v
+- SKU Check Interval: %v
+- Stock Re-alert Interval: %v
+Targets:
+%s`,
+		config.Intervals.Stock,
+		config.Intervals.Sku,
+		config.Intervals.Realert,
+		formatTargets(config.Targets))
+
+	return sendNotification(Notification{
+		Title:    "FE Tracker Started",
+		Body:     startupMsg,
+		Priority: 3,
+		Event:    EventStartup,
+	})
 }
 
-// Update checkInventory to accept context and timezone
-func checkInventory(ctx context.Context, sku, locale string) error {
-	metrics.updateLastCheck() // Add this line
-	url := fmt.Sprintf("https://api.store.nvidia.com/partner/v1/feinventory?skus=%s&locale=%s", sku, locale)
+// Request the inventory of several SKUs of one locale at once. Listings are
+// keyed by upper case SKU, SKUs without a listing are missing from the map.
+func fetchInventory(ctx context.Context, locale string, skus []string, target Target) (map[string]InventoryItem, error) {
+	url := fmt.Sprintf("https://api.store.nvidia.com/partner/v1/feinventory?skus=%s&locale=%s", strings.Join(skus, ","), locale)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("creating inventory request: %v", err)
+	var inventory InventoryResponse
+	if err := upstream.GetJSON(ctx, EndpointFeInventory, url, target, &inventory); err != nil {
+		return nil, fmt.Errorf("inventory request failed: %w", err)
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Accept", "application/json")
+	items := make(map[string]InventoryItem, len(inventory.ListMap))
+	for _, item := range inventory.ListMap {
+		sku := strings.ToUpper(item.FeSKU)
+		if sku == "" && len(skus) == 1 {
+			sku = strings.ToUpper(skus[0]) // Single SKU requests may omit it
+		}
+		if sku != "" {
+			items[sku] = item
+		}
+	}
+	return items, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("inventory request failed: %v", err)
+// Apply the listing of sku, if any, to the stock state of the monitor
+func (m *Monitor) applyInventory(sku string, items map[string]InventoryItem) error {
+	m.updateLastCheck()
+
+	item, listed := items[strings.ToUpper(sku)]
+	inStock := listed && item.IsActive != "false"
+	purchaseURL := item.ProductURL
+	if listed {
+		m.observePrice(sku, parsePrice(item.Price), item.Price)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading inventory response: %v", err)
+	// Only alert on state transitions, the tracker decides when to re-alert
+	transition, lasted := m.stock.Observe(sku, inStock, time.Now())
+	if inStock {
+		m.updatePurchaseURL(purchaseURL)
+	} else {
+		// Clear purchase URL if not available
+		m.updatePurchaseURL("")
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("inventory check returned status: %d", resp.StatusCode)
+	// Keep a history of every transition, notifySoldOut records the other one
+	if transition == StockCameInStock {
+		store.Record(StoredEvent{
+			Type:    StoredStockIn,
+			Target:  m.Target.ID,
+			SKU:     sku,
+			Details: m.productDetails(map[string]string{"purchase_url": purchaseURL}),
+		})
+		publishEvent(SSEStockIn, m.Target.ID, m.stockEvent(sku, purchaseURL, 0))
 	}
 
-	var inventory InventoryResponse
-	if err := json.Unmarshal(body, &inventory); err != nil {
-		return fmt.Errorf("parsing inventory JSON: %v", err)
+	switch transition {
+	case StockCameInStock, StockStillInStock:
+		title := "STOCK FOUND!"
+		event := EventStockIn
+		if transition == StockStillInStock {
+			title = fmt.Sprintf("STILL IN STOCK (%s)", simpleDuration(lasted))
+			event = EventStockReminder
+		}
+
+		msg := fmt.Sprintf(`**%s IN STOCK!**
+
+- Locale: %s
+- SKU: %s%s
+
+[Direct purchase link](%s)`,
+			m.Target.Product,
+			m.Target.Locale,
+			sku,
+			m.priceLine(),
+			purchaseURL)
+
+		log.Print(msg)
+		return sendNotification(Notification{
+			Title:    title,
+			Body:     msg,
+			Priority: 5, // Highest priority
+			URL:      purchaseURL,
+			Target:   m.Target.ID,
+			Tags:     []string{m.Target.Locale},
+			Event:    event,
+			Markdown: true,
+		})
+	case StockSoldOut:
+		return m.notifySoldOut(sku, lasted)
 	}
 
-	if len(inventory.ListMap) > 0 {
-		item := inventory.ListMap[0]
-		if item.IsActive != "false" {
-			// Update purchase URL in metrics
-			metrics.updatePurchaseURL(item.ProductURL)
+	return nil
+}
 
-			msg := fmt.Sprintf(`RTX %s IN STOCK!
-SKU: %s
+// Record and announce the end of stock of sku
+func (m *Monitor) notifySoldOut(sku string, lasted time.Duration) error {
+	store.Record(StoredEvent{
+		Type:    StoredStockOut,
+		Target:  m.Target.ID,
+		SKU:     sku,
+		Details: m.productDetails(map[string]string{"duration": lasted.Round(time.Second).String()}),
+	})
+	publishEvent(SSEStockOut, m.Target.ID, m.stockEvent(sku, "", lasted))
+
+	msg := fmt.Sprintf(`%s sold out again.
+
+- Locale: %s
+- SKU: %s%s
+- Stock lasted: **%s**`,
+		m.Target.Product,
+		m.Target.Locale,
+		sku,
+		m.priceLine(),
+		formatStockDuration(lasted))
+
+	log.Print(msg)
+	return sendNotification(Notification{
+		Title:    "Sold Out",
+		Body:     msg,
+		Priority: 3,
+		Target:   m.Target.ID,
+		Tags:     []string{m.Target.Locale},
+		Event:    EventStockOut,
+		Markdown: true,
+	})
+}
 
-Direct purchase link:
-%s
+// Like simpleDuration but with seconds, drops are often shorter than a minute
+func formatStockDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return simpleDuration(d)
+}
 
-`,
-				sku,
-				sku,
-				item.ProductURL)
+// Poll feinventory for the SKU found by the last catalog discovery of this
+// target alone, used for re-checks. Scheduled checks go through
+// checkLocaleStock.
+func (m *Monitor) checkStock(ctx context.Context) error {
+	m.mu.Lock()
+	sku := m.currentSKU
+	m.mu.Unlock()
 
-			log.Print(msg)
-			return sendNtfyNotification("STOCK FOUND!", msg, 5) // Highest priority
-		}
+	// Nothing to poll until discovery found the FE SKU
+	if sku == "" {
+		return errCheckSkipped
 	}
 
-	// Clear purchase URL if not available
-	metrics.updatePurchaseURL("")
-	return nil
+	items, err := fetchInventory(ctx, m.Target.Locale, []string{sku}, m.Target)
+	if err != nil {
+		errorTracker.AddError(m.Target.ID, err)
+		return fmt.Errorf("inventory check failed: %w", err)
+	}
+	return m.applyInventory(sku, items)
 }
 
-// Update checkSkuStatus to accept and use context and timezone
-func checkSkuStatus(ctx context.Context, config Config) error {
-	response, err := makeRequest(ctx, config.ApiURL)
+// Search the catalog for the FE card and cache its SKU
+func (m *Monitor) discoverSKU(ctx context.Context) error {
+	response, err := makeRequest(ctx, m.Target)
 	if err != nil {
-		return fmt.Errorf("API request failed: %v", err)
+		errorTracker.AddError(m.Target.ID, err)
+		return fmt.Errorf("API request failed: %w", err)
 	}
 
-	foundFE := false
-	for _, product := range response.SearchedProducts.ProductDetails {
-		if product.IsFounderEdition && strings.Contains(product.DisplayName, config.GpuModel) {
-			foundFE = true
-			metrics.updateSKU(product.ProductSKU)
-
-			if err := checkInventory(ctx, product.ProductSKU, config.Locale); err != nil {
-				log.Printf("Inventory check failed: %v", err)
+	var matches []string
+	var match *SearchProduct
+	for i, product := range response.SearchedProducts.ProductDetails {
+		if m.Target.Matches(product.DisplayName, product.ProductSKU, product.IsFounderEdition) {
+			if match == nil {
+				match = &response.SearchedProducts.ProductDetails[i]
 			}
-			break
+			matches = append(matches, fmt.Sprintf("%s (%s)", product.DisplayName, product.ProductSKU))
 		}
 	}
 
-	if !foundFE {
-		log.Printf("No matching FE card found")
+	switch {
+	case match == nil && m.Target.PinnedSKU != "":
+		log.Printf("[%s] Pinned SKU %s not listed", m.Target.ID, m.Target.PinnedSKU)
+		return nil
+	case match == nil:
+		log.Printf("[%s] No matching FE card found", m.Target.ID)
+		return nil
+	case len(matches) > 1:
+		log.Printf("[%s] %d products match, using the first, set a name pattern to choose: %s",
+			m.Target.ID, len(matches), strings.Join(matches, ", "))
 	}
 
+	m.updateProduct(*match)
+	m.applySKU(match.ProductSKU)
 	return nil
 }
 
-func cleanup(config Config) {
-	msg := fmt.Sprintf(`- Locale: %s
-- GPU Model: %s`,
-		config.Locale,
-		config.GpuModel)
+// Switch to the discovered FE SKU. On a rotation the stock state of the old
+// SKU is closed, if it was still in stock that ends now since nothing will
+// poll it anymore.
+func (m *Monitor) applySKU(sku string) {
+	change := m.updateSKU(sku)
+	if change == nil {
+		return
+	}
+	m.notifySKUChange(*change)
+	if transition, lasted := m.stock.Retire(change.OldSKU, change.Time); transition == StockSoldOut {
+		m.updatePurchaseURL("")
+		if err := m.notifySoldOut(change.OldSKU, lasted); err != nil {
+			log.Printf("Failed to send sold out notification: %v", err)
+		}
+	}
+}
+
+// Announce a SKU rotation, these often precede a drop
+func (m *Monitor) notifySKUChange(change SKUChange) {
+	publishEvent(SSESKUChanged, m.Target.ID, change)
+	store.Record(StoredEvent{
+		Type:    StoredSKUChanged,
+		Time:    change.Time,
+		Target:  change.Target,
+		SKU:     change.NewSKU,
+		Details: m.productDetails(map[string]string{"old_sku": change.OldSKU, "new_sku": change.NewSKU}),
+	})
+
+	msg := fmt.Sprintf(`%s SKU changed
+
+- Locale: %s
+- Old SKU: %s
+- New SKU: **%s**
+- Changed at: %s`,
+		m.Target.Product,
+		m.Target.Locale,
+		change.OldSKU,
+		change.NewSKU,
+		change.Time.Format("2006-01-02 15:04:05"))
+
+	log.Print(msg)
+	if err := sendNotification(Notification{
+		Title:    "SKU Changed",
+		Body:     msg,
+		Priority: 4,
+		URL:      m.Target.ProductURL,
+		Target:   m.Target.ID,
+		Tags:     []string{m.Target.Locale},
+		Event:    EventSKUChanged,
+		Markdown: true,
+	}); err != nil {
+		log.Printf("Failed to send SKU change notification: %v", err)
+	}
+}
 
-	if err := sendNtfyNotification("FE Tracker Stopped", msg, 3); err != nil {
+func cleanup(config Config) {
+	msg := fmt.Sprintf("Targets:\n%s", formatTargets(config.Targets))
+
+	if err := sendNotification(Notification{
+		Title:    "FE Tracker Stopped",
+		Body:     msg,
+		Priority: 3,
+		Event:    EventShutdown,
+	}); err != nil {
 		log.Printf("Failed to send shutdown notification: %v", err)
 	} else {
-		log.Printf("Shutdown notification sent successfully")
+		log.Printf("Shutdown notification queued")
 	}
 }
 
@@ -509,51 +647,57 @@ func sendDailyReport() {
 
 	metrics.mu.Lock()
 	report := fmt.Sprintf(`- Uptime: %s
-- Current SKU: %s
 - API Requests (24h): %d
 - Errors (24h): %d
-- Notifications Sent: %d`,
+- Notifications Sent (24h): %d`,
 		simpleDuration(time.Since(metrics.StartTime)),
-		metrics.CurrentSKU,
-		metrics.ApiRequests,
+		metrics.apiRequests.Count(24*time.Hour),
 		errorTracker.get24hErrorCount(),
-		metrics.NtfySent,
+		metrics.notifications.Count(24*time.Hour),
 	)
 	metrics.mu.Unlock()
 
-	if err := sendNtfyNotification("Status Report", report, 3); err != nil {
+	// Add one line per target
+	report += "\nTargets:"
+	for _, target := range scheduler.targetStatuses() {
+		stock := "out of stock"
+		if target.InStock {
+			stock = "IN STOCK"
+		}
+		sku := target.CurrentSKU
+		if sku == "" {
+			sku = "unknown"
+		}
+		report += fmt.Sprintf("\n- %s: SKU %s, %s", target.ID, sku, stock)
+	}
+
+	if err := sendNotification(Notification{
+		Title:    "Status Report",
+		Body:     report,
+		Priority: 3,
+		Event:    EventReport,
+	}); err != nil {
 		log.Printf("Failed to send daily report: %v", err)
 	} else {
-		log.Printf("Daily report sent successfully")
+		log.Printf("Daily report queued")
 	}
 }
 
-// Update daily report check to use timezone
-func startMonitoring(ctx context.Context, config Config) error {
-	// Convert interval strings to durations
-	stockInterval, err := time.ParseDuration(config.StockCheckInterval + "ms")
-	if err != nil {
-		return fmt.Errorf("invalid stock check interval: %v", err)
-	}
-
-	skuInterval, err := time.ParseDuration(config.SkuCheckInterval + "ms")
-	if err != nil {
-		return fmt.Errorf("invalid SKU check interval: %v", err)
-	}
-
-	log.Printf("Starting monitoring (Stock: %v, SKU: %v)", stockInterval, skuInterval)
-
-	// Create ticker for stock and SKU checks
-	stockTicker := time.NewTicker(stockInterval)
-	skuTicker := time.NewTicker(skuInterval)
-	defer stockTicker.Stop()
-	defer skuTicker.Stop()
+// Intervals are the parsed check intervals from Config
+type Intervals struct {
+	Stock   time.Duration
+	Sku     time.Duration
+	Realert time.Duration
+}
 
-	// Create error channel for goroutine errors
-	errChan := make(chan error, 1)
+// Update daily report check to use timezone
+func startMonitoring(ctx context.Context) error {
+	monitors, intervals := scheduler.snapshot()
+	log.Printf("Starting monitoring of %d target(s) (Stock: %v, SKU: %v)",
+		len(monitors), intervals.Stock, intervals.Sku)
 
-	// Ensure cleanup runs on exit
-	defer cleanup(config)
+	// Ensure cleanup runs on exit, with the targets after any reloads
+	defer func() { cleanup(currentConfig()) }()
 
 	// Add daily report ticker with timezone
 	reportTicker := time.NewTicker(time.Minute)
@@ -563,105 +707,91 @@ func startMonitoring(ctx context.Context, config Config) error {
 		for {
 			now := time.Now()
 			currentTime := now.Format("15:04")
-			if currentTime == DAILY_REPORT_TIME {
+			if currentTime == currentConfig().DailyReportTime {
 				sendDailyReport()
 			}
 			<-reportTicker.C
 		}
 	}()
 
-	// Monitoring loop
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case err := <-errChan:
-			return fmt.Errorf("monitoring error: %v", err)
-		case <-stockTicker.C:
-			// Use goroutine for stock check to prevent blocking
-			go func() {
-				if err := checkSkuStatus(ctx, config); err != nil {
-					select {
-					case errChan <- err:
-					default:
-						log.Printf("Check failed: %v", err)
-					}
-				}
-			}()
-		case <-skuTicker.C:
-			// Use goroutine for SKU check to prevent blocking
-			go func() {
-				if err := checkSkuStatus(ctx, config); err != nil {
-					select {
-					case errChan <- err:
-					default:
-						log.Printf("SKU check failed: %v", err)
-					}
-				}
-			}()
-		}
-	}
+	return scheduler.Supervise(ctx)
 }
 
-// Update handleStatus to properly initialize the status struct with current data
-func handleStatus(w http.ResponseWriter, r *http.Request) {
+// Status payload shared by /status and /events
+type StatusSnapshot struct {
+	Status  string `json:"status"`
+	Uptime  string `json:"uptime"`
+	Metrics struct {
+		CurrentSKU      string    `json:"current_sku"`
+		ErrorCount24h   int       `json:"error_count_24h"`
+		ApiRequests     int       `json:"api_requests_24h"`
+		Notifications   int       `json:"notifications_sent_24h"`
+		StartTime       time.Time `json:"start_time"`
+		LastStatusCheck time.Time `json:"last_status_check"`
+		PurchaseURL     string    `json:"purchase_url"`
+	} `json:"metrics"`
+	Targets      []TargetStatus  `json:"targets"`
+	Notifiers    []NotifierStats `json:"notifiers"`
+	Queue        QueueStats      `json:"notification_queue"`
+	Polling      PollingStatus   `json:"polling"`
+	Proxies      []ProxyStats    `json:"proxies,omitempty"`
+	SnoozedUntil *time.Time      `json:"snoozed_until,omitempty"`
+}
+
+func buildStatusSnapshot() StatusSnapshot {
+	targets := scheduler.targetStatuses()
+
 	metrics.mu.Lock()
-	status := struct {
-		Status  string `json:"status"`
-		Uptime  string `json:"uptime"`
-		Metrics struct {
-			CurrentSKU      string    `json:"current_sku"`
-			ErrorCount24h   int       `json:"error_count_24h"`
-			ApiRequests     int       `json:"api_requests_24h"`
-			NtfySent        int       `json:"ntfy_messages_sent"`
-			StartTime       time.Time `json:"start_time"`
-			LastStatusCheck time.Time `json:"last_status_check"`
-			PurchaseURL     string    `json:"purchase_url"`
-		} `json:"metrics"`
-	}{
-		Status: "running",
-		Uptime: simpleDuration(time.Since(metrics.StartTime)),
-		Metrics: struct {
-			CurrentSKU      string    `json:"current_sku"`
-			ErrorCount24h   int       `json:"error_count_24h"`
-			ApiRequests     int       `json:"api_requests_24h"`
-			NtfySent        int       `json:"ntfy_messages_sent"`
-			StartTime       time.Time `json:"start_time"`
-			LastStatusCheck time.Time `json:"last_status_check"`
-			PurchaseURL     string    `json:"purchase_url"`
-		}{
-			CurrentSKU:      metrics.CurrentSKU,
-			ErrorCount24h:   errorTracker.get24hErrorCount(),
-			ApiRequests:     metrics.ApiRequests,
-			NtfySent:        metrics.NtfySent,
-			StartTime:       metrics.StartTime,
-			LastStatusCheck: metrics.LastStatusCheck,
-			PurchaseURL:     metrics.PurchaseURL,
-		},
+	status := StatusSnapshot{
+		Status:    scheduler.Health(),
+		Uptime:    simpleDuration(time.Since(metrics.StartTime)),
+		Targets:   targets,
+		Notifiers: notifierStats(),
+		Queue:     dispatcher.Stats(),
+		Proxies:   proxyPool.Stats(),
+	}
+	if scheduler != nil {
+		_, intervals := scheduler.snapshot()
+		status.Polling = upstreamBackoff.Status(intervals)
+	}
+	if until := snoozeEnd(); !until.IsZero() {
+		status.SnoozedUntil = &until
 	}
+	status.Metrics.ErrorCount24h = errorTracker.get24hErrorCount()
+	status.Metrics.ApiRequests = metrics.apiRequests.Count(24 * time.Hour)
+	status.Metrics.Notifications = metrics.notifications.Count(24 * time.Hour)
+	status.Metrics.StartTime = metrics.StartTime
+	status.Metrics.LastStatusCheck = metrics.LastStatusCheck
 	metrics.mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	// Keep the single-target fields for older clients
+	skus := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if target.CurrentSKU != "" {
+			skus = append(skus, target.CurrentSKU)
+		}
+		if status.Metrics.PurchaseURL == "" && target.PurchaseURL != "" {
+			status.Metrics.PurchaseURL = target.PurchaseURL
+		}
+	}
+	status.Metrics.CurrentSKU = strings.Join(skus, ", ")
+
+	return status
 }
 
-// Add connection tracking
-var (
-	activeConnections sync.Map
-	connectionID      uint64
-	connectionMutex   sync.Mutex
-)
+// Update handleStatus to properly initialize the status struct with current data
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildStatusSnapshot())
+}
 
-// Update handleEvents to track connections
+// Stream status updates and typed events from the hub
 func handleEvents(w http.ResponseWriter, r *http.Request) {
-	// Generate unique connection ID
-	connectionMutex.Lock()
-	connID := atomic.AddUint64(&connectionID, 1)
-	connectionMutex.Unlock()
-
-	// Store connection in active connections map
-	activeConnections.Store(connID, time.Now())
-	defer activeConnections.Delete(connID)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
 
 	// Set headers for SSE
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -670,137 +800,41 @@ func handleEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("X-Accel-Buffering", "no")
 
-	// Increase read timeout for the specific connection
-	if _, ok := w.(http.Flusher); !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
-	}
+	lastEventID, resume := parseLastEventID(r)
 
-	// Create channel for client disconnect detection
-	done := r.Context().Done()
+	// Initial connection message with the reconnect delay
+	fmt.Fprintf(w, "retry: %d\nevent: connected\ndata: {\"status\":\"connected\"}\n\n", hubRetryHint.Milliseconds())
+	flusher.Flush()
 
-	// Create ticker for updates with shorter intervals
-	ticker := time.NewTicker(1 * time.Second)      // More frequent updates
-	pingTicker := time.NewTicker(10 * time.Second) // Keep ping interval
-	defer ticker.Stop()
-	defer pingTicker.Stop()
+	id, frames := hub.Subscribe(lastEventID, resume)
+	defer hub.Unsubscribe(id)
 
-	// Initial connection message and data
-	fmt.Fprintf(w, "event: connected\ndata: {\"status\":\"connected\"}\n\n")
-	w.(http.Flusher).Flush()
-
-	// Immediately send first data update
-	sendStatusUpdate(w)
-
-	// Create cleanup ticker
-	cleanupTicker := time.NewTicker(30 * time.Second)
-	defer cleanupTicker.Stop()
-
-	// Create done channel for cleanup
-	doneCleanup := make(chan struct{})
-	defer close(doneCleanup)
-
-	// Start cleanup goroutine
-	go func() {
-		for {
-			select {
-			case <-doneCleanup:
-				return
-			case <-cleanupTicker.C:
-				runtime.GC() // Suggest garbage collection
-			}
-		}
-	}()
-
-	// Send updates with connection monitoring
+	done := r.Context().Done()
 	for {
 		select {
 		case <-done:
 			return
-		case <-cleanupTicker.C:
-			// Update last active time
-			activeConnections.Store(connID, time.Now())
-		case <-pingTicker.C:
-			if err := sendPing(w); err != nil {
-				return
+		case frame, ok := <-frames:
+			if !ok {
+				return // Dropped by the hub or shutting down
 			}
-		case <-ticker.C:
-			if err := sendStatusUpdate(w); err != nil {
+			if _, err := w.Write(frame.sse); err != nil {
 				return
 			}
+			flusher.Flush()
 		}
 	}
 }
 
-// Helper function to send status update
-func sendStatusUpdate(w http.ResponseWriter) error {
-	metrics.mu.Lock()
-	status := struct {
-		Status  string `json:"status"`
-		Uptime  string `json:"uptime"`
-		Metrics struct {
-			CurrentSKU      string    `json:"current_sku"`
-			ErrorCount24h   int       `json:"error_count_24h"`
-			ApiRequests     int       `json:"api_requests_24h"`
-			NtfySent        int       `json:"ntfy_messages_sent"`
-			StartTime       time.Time `json:"start_time"`
-			LastStatusCheck time.Time `json:"last_status_check"`
-			PurchaseURL     string    `json:"purchase_url,omitempty"`
-		} `json:"metrics"`
-	}{
-		Status: "running",
-		Uptime: simpleDuration(time.Since(metrics.StartTime)),
-	}
-
-	// Copy metrics data
-	status.Metrics.CurrentSKU = metrics.CurrentSKU
-	status.Metrics.ErrorCount24h = errorTracker.get24hErrorCount()
-	status.Metrics.ApiRequests = metrics.ApiRequests
-	status.Metrics.NtfySent = metrics.NtfySent
-	status.Metrics.StartTime = metrics.StartTime
-	status.Metrics.LastStatusCheck = metrics.LastStatusCheck
-	status.Metrics.PurchaseURL = metrics.PurchaseURL
-	metrics.mu.Unlock()
-
-	data, err := json.Marshal(status)
-	if err != nil {
-		return fmt.Errorf("marshal error: %v", err)
-	}
-
-	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
-		return fmt.Errorf("write error: %v", err)
-	}
-
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
-	}
-	return nil
-}
-
-// Add helper functions for sending data
-func sendPing(w http.ResponseWriter) error {
-	_, err := fmt.Fprint(w, ": ping\n\n")
-	if err != nil {
-		return err
+// Event ID to resume from: the browser's Last-Event-ID, or the query for
+// manual reconnects. A missing or malformed ID means a fresh start.
+func parseLastEventID(r *http.Request) (uint64, bool) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
 	}
-	w.(http.Flusher).Flush()
-	return nil
-}
-
-// Update performHealthCheck function
-func performHealthCheck() bool {
-	metrics.mu.Lock()
-	lastCheck := metrics.LastStatusCheck
-	metrics.mu.Unlock()
-
-	timeSinceLastCheck := time.Since(lastCheck)
-	if timeSinceLastCheck > 5*time.Minute {
-		log.Printf("Health check failed: No activity in %.1f minutes",
-			timeSinceLastCheck.Minutes())
-		return false
-	}
-
-	return true
+	lastEventID, err := strconv.ParseUint(lastID, 10, 64)
+	return lastEventID, err == nil
 }
 
 // Update log format to be simpler
@@ -825,6 +859,8 @@ func staticFileServer() http.Handler {
 func main() {
 	// Add command line flag for health check
 	healthCheck := flag.Bool("health-check", false, "Perform health check and exit")
+	configFile := flag.String("config", "", "Config file (default $CONFIG_FILE or ./config.toml if present)")
+	flag.Var(settingFlags(flagSettings), "set", "Override a setting as KEY=VALUE, repeatable")
 	flag.Parse()
 
 	// Handle health check request
@@ -835,17 +871,59 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Set global ntfy topic at startup
-	ntfyTopic = os.Getenv("NTFY_TOPIC")
-	if ntfyTopic == "" {
-		log.Fatal("NTFY_TOPIC environment variable is required")
+	if err := loadConfigFile(*configFile); err != nil {
+		log.Fatalf("Failed to read config file: %v", err)
 	}
-
-	config, err := loadEnvConfig()
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Set up notification channels at startup
+	if err := loadNotifiers(); err != nil {
+		log.Fatalf("Failed to configure notifications: %v", err)
+	}
+	if err := loadRules(); err != nil {
+		log.Fatalf("Failed to load alert rules: %v", err)
+	}
+	if err := loadNotifyProxy(); err != nil {
+		log.Fatalf("Failed to configure notification proxy: %v", err)
+	}
+
+	// Rotate browser header profiles on upstream requests
+	upstream, err = loadUpstreamClient()
+	if err != nil {
+		log.Fatalf("Failed to configure header profiles: %v", err)
+	}
+
+	// Route upstream requests through the proxy pool, if any
+	proxyPool, err = loadProxyPool()
+	if err != nil {
+		log.Fatalf("Failed to configure proxies: %v", err)
+	}
+
+	// Open the event history and restore the 24h counters from it
+	store, err = loadEventStore()
+	if err != nil {
+		log.Fatalf("Failed to open event store: %v", err)
+	}
+	seedMetricsFromStore(store)
+	storeDone := make(chan struct{})
+	storeStopped := make(chan struct{})
+	go func() {
+		defer close(storeStopped)
+		runStoreMaintenance(storeDone)
+	}()
+
+	// Start the delivery queue, replaying the outbox
+	dispatcher, err = loadDispatcher()
+	if err != nil {
+		log.Fatalf("Failed to set up notification queue: %v", err)
+	}
+
+	scheduler = newScheduler(config.Targets, config.Intervals)
+	applyConfig(config)
+
 	// Setup logger
 	setupLogger()
 
@@ -869,7 +947,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := startMonitoring(ctx, config); err != nil && !errors.Is(err, context.Canceled) {
+		if err := startMonitoring(ctx); err != nil && !errors.Is(err, context.Canceled) {
 			log.Printf("Monitoring failed: %v", err)
 			cancel() // Cancel context if monitoring fails with non-cancellation error
 		}
@@ -893,6 +971,14 @@ func main() {
 
 	http.HandleFunc("/status", handleStatus)
 	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/api/snooze", handleSnooze)
+	http.HandleFunc("/api/history", handleHistory)
+	http.HandleFunc("/api/rules", handleRules)
+	http.HandleFunc("/api/rules/test", handleRulesTest)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
 
 	// Create HTTP server with adjusted timeout settings for SSE
 	srv := &http.Server{
@@ -905,6 +991,12 @@ func main() {
 		Handler: nil, // Will use default ServeMux
 	}
 
+	// Fan status updates out to /events clients
+	go hub.Run(ctx)
+
+	// Apply config file changes without a restart
+	go watchConfig(ctx)
+
 	// Start HTTP server in a goroutine
 	wg.Add(1)
 	go func() {
@@ -916,22 +1008,6 @@ func main() {
 		}
 	}()
 
-	// Add connection cleanup routine
-	go func() {
-		for {
-			time.Sleep(5 * time.Minute)
-			now := time.Now()
-			activeConnections.Range(func(key, value interface{}) bool {
-				if lastActive, ok := value.(time.Time); ok {
-					if now.Sub(lastActive) > 10*time.Minute {
-						activeConnections.Delete(key)
-					}
-				}
-				return true
-			})
-		}
-	}()
-
 	// Update daily report ticker to use config timezone
 	reportTicker := time.NewTicker(time.Minute)
 	defer reportTicker.Stop()
@@ -944,7 +1020,7 @@ func main() {
 			case <-reportTicker.C:
 				now := time.Now()
 				currentTime := now.Format("15:04")
-				if currentTime == DAILY_REPORT_TIME {
+				if currentTime == currentConfig().DailyReportTime {
 					sendDailyReport()
 				}
 			}
@@ -968,5 +1044,17 @@ func main() {
 
 	// Wait for all goroutines to finish
 	wg.Wait()
+
+	// Give queued notifications like the shutdown message a chance to go out
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer drainCancel()
+	dispatcher.Drain(drainCtx)
+
+	// Write the final request rollup before closing the store
+	close(storeDone)
+	<-storeStopped
+	if err := store.Close(); err != nil {
+		log.Printf("Event store close error: %v", err)
+	}
 	log.Println("Shutdown complete")
 }
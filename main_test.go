@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Use a fresh event store in a temporary dir for the test
+func testStore(t *testing.T) *EventStore {
+	s, err := openEventStore(t.TempDir(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	t.Cleanup(func() {
+		store = nil
+		s.Close()
+	})
+	return s
+}
+
+// Typed events published to the hub after ID since
+func hubEvents(since uint64, eventType string) [][]byte {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	var frames [][]byte
+	for _, frame := range hub.history {
+		if frame.id > since && bytes.Contains(frame.sse, []byte("\nevent: "+eventType+"\n")) {
+			frames = append(frames, frame.sse)
+		}
+	}
+	return frames
+}
+
+func hubLastID() uint64 {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.lastEventID
+}
+
+func testMonitor(t *testing.T, rawURL string) *Monitor {
+	target, err := parseTarget(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMonitor(target, 5*time.Minute)
+}
+
+func TestSKURotationClosesStock(t *testing.T) {
+	s := testStore(t)
+	m := testMonitor(t, "https://marketplace.nvidia.com/de-de/consumer/graphics-cards/nvidia-geforce-rtx-5090/")
+	since := hubLastID()
+
+	m.applySKU("OLD5090FE")
+	if err := m.applyInventory("OLD5090FE", map[string]InventoryItem{
+		"OLD5090FE": {IsActive: "true", ProductURL: "https://example.com/buy"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if status := m.status(); !status.InStock || status.PurchaseURL == "" {
+		t.Fatalf("before rotation in stock %v, purchase URL %q", status.InStock, status.PurchaseURL)
+	}
+
+	m.applySKU("NEW5090FE")
+
+	status := m.status()
+	if status.CurrentSKU != "NEW5090FE" || status.InStock || status.PurchaseURL != "" {
+		t.Errorf("after rotation SKU %s, in stock %v, purchase URL %q", status.CurrentSKU, status.InStock, status.PurchaseURL)
+	}
+	if state, _ := m.stock.State("OLD5090FE"); state != StockOutOfStock {
+		t.Errorf("old SKU state = %v", state)
+	}
+
+	var types []string
+	for _, event := range s.Since("", time.Time{}) {
+		types = append(types, event.Type+" "+event.SKU)
+	}
+	want := []string{"stock_in OLD5090FE", "sku_changed NEW5090FE", "stock_out OLD5090FE"}
+	if len(types) != len(want) {
+		t.Fatalf("stored events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("stored events = %v, want %v", types, want)
+			break
+		}
+	}
+	if out := hubEvents(since, SSEStockOut); len(out) != 1 || !bytes.Contains(out[0], []byte(`"sku":"OLD5090FE"`)) {
+		t.Errorf("stock_out events = %q", out)
+	}
+
+	// A rotation away from a SKU that is out of stock only announces the change
+	since = hubLastID()
+	m.applySKU("NEWER5090FE")
+	if out := hubEvents(since, SSEStockOut); len(out) != 0 {
+		t.Errorf("stock_out events for an out of stock SKU = %q", out)
+	}
+	if changed := hubEvents(since, SSESKUChanged); len(changed) != 1 {
+		t.Errorf("sku_changed events = %q", changed)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Words of a product name, splitting letters from digits so "5070Ti" and
+// "5070 Ti" compare equal
+var nameWordPattern = regexp.MustCompile(`[a-z]+|[0-9]+`)
+
+// Suffixes that turn a model number into a different card
+var modelSuffixes = map[string]bool{"ti": true, "super": true, "d": true}
+
+func nameWords(name string) []string {
+	return nameWordPattern.FindAllString(strings.ToLower(name), -1)
+}
+
+// matchesModel reports whether name contains exactly the model, so a
+// 5070 doesn't match "RTX 5070 Ti" and a 5070 Ti doesn't match "RTX 5070"
+func matchesModel(name, model string) bool {
+	words, modelWords := nameWords(name), nameWords(model)
+	if len(modelWords) == 0 {
+		return false
+	}
+	for i := 0; i+len(modelWords) <= len(words); i++ {
+		if strings.Join(words[i:i+len(modelWords)], " ") != strings.Join(modelWords, " ") {
+			continue
+		}
+		if next := i + len(modelWords); next < len(words) && modelSuffixes[words[next]] {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Matches reports whether a search result is the product of the target. A
+// pinned SKU matches only itself, otherwise the result has to be a
+// Founders Edition of the model and match the name pattern, if any.
+func (t Target) Matches(displayName, sku string, founderEdition bool) bool {
+	if t.PinnedSKU != "" {
+		return sku == t.PinnedSKU
+	}
+	if !founderEdition || !matchesModel(displayName, t.GpuModel) {
+		return false
+	}
+	return t.namePattern == nil || t.namePattern.MatchString(displayName)
+}
+
+// Format a URL slug word of a model name: 5070, Ti, Super, RTX, Titan
+func modelWord(word string) string {
+	switch {
+	case word == "":
+		return ""
+	case word[0] >= '0' && word[0] <= '9':
+		return strings.ToUpper(word)
+	case len(word) <= 3 && word != "ti":
+		return strings.ToUpper(word)
+	}
+	return strings.ToUpper(word[:1]) + word[1:]
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMatchesModel(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		want  bool
+	}{
+		{"NVIDIA GeForce RTX 5070 Ti", "5070 Ti", true},
+		{"NVIDIA GeForce RTX 5070Ti", "5070 Ti", true},
+		{"NVIDIA GeForce RTX 5070", "5070 Ti", false},
+		{"NVIDIA GeForce RTX 5070 Ti", "5070", false},
+		{"NVIDIA GeForce RTX 4080 SUPER", "4080 Super", true},
+		{"NVIDIA GeForce RTX 4080", "4080 Super", false},
+		{"NVIDIA GeForce RTX 4080 SUPER", "4080", false},
+		{"NVIDIA GeForce RTX 4070 Ti SUPER", "4070 Ti Super", true},
+		{"NVIDIA GeForce RTX 4070 Ti SUPER", "4070 Ti", false},
+		{"NVIDIA GeForce RTX 4070 Ti", "4070 Ti Super", false},
+		{"NVIDIA GeForce RTX 4070 SUPER", "4070 Ti Super", false},
+		{"NVIDIA GeForce RTX 5090 D", "5090", false},
+		{"NVIDIA TITAN RTX", "Titan RTX", true},
+		{"NVIDIA TITAN V", "Titan RTX", false},
+		{"NVIDIA GeForce RTX 50900", "5090", false},
+		{"NVIDIA GeForce RTX 5090", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesModel(tt.name, tt.model); got != tt.want {
+			t.Errorf("matchesModel(%q, %q) = %v, want %v", tt.name, tt.model, got, tt.want)
+		}
+	}
+}
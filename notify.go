@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types a notification can be sent for
+const (
+	EventStockIn        = "stock_in"
+	EventStockReminder  = "stock_reminder"
+	EventStockOut       = "stock_out"
+	EventSKUChanged     = "sku_changed"
+	EventErrorThreshold = "error_threshold"
+	EventReport         = "report"
+	EventStartup        = "startup"
+	EventShutdown       = "shutdown"
+)
+
+// Notification is a channel independent alert
+type Notification struct {
+	Title    string   `json:"title"`
+	Body     string   `json:"body"`
+	Priority int      `json:"priority"` // 1 (min) to 5 (max), same scale as ntfy
+	URL      string   `json:"url,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Event    string   `json:"event"`
+	Markdown bool     `json:"markdown,omitempty"` // Body uses Markdown formatting
+	Target   string   `json:"target,omitempty"`   // Target ID for per-target alerts
+}
+
+// Notifier delivers notifications to one backend
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+	Addr() string // host:port of the backend, used by the readiness probe
+}
+
+// NotifierStats is the per-channel view exposed in /status
+type NotifierStats struct {
+	Name      string `json:"name"`
+	Sent      int    `json:"sent"`
+	Failed    int    `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Wrap a notifier with its delivery counters
+type notifierChannel struct {
+	notifier Notifier
+	stats    NotifierStats
+	mu       sync.Mutex
+}
+
+// Notifier currently behind the channel, swapped on config reload
+func (c *notifierChannel) backend() Notifier {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.notifier
+}
+
+func (c *notifierChannel) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stats.Failed++
+		c.stats.LastError = err.Error()
+		return
+	}
+	c.stats.Sent++
+}
+
+// Configured notification channels, replaced as a whole on config reload
+var (
+	notifiers   []*notifierChannel
+	notifiersMu sync.RWMutex
+)
+
+func currentNotifiers() []*notifierChannel {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	return notifiers
+}
+
+// Timeout for a single delivery attempt
+const notifyTimeout = 10 * time.Second
+
+// Set up notifiers at startup
+func loadNotifiers() error {
+	channels, err := buildNotifiers()
+	if err != nil {
+		return err
+	}
+	setNotifiers(channels)
+	return nil
+}
+
+// Build notifiers from NOTIFY_CHANNELS (comma separated, default "ntfy")
+func buildNotifiers() ([]*notifierChannel, error) {
+	names := setting("NOTIFY_CHANNELS")
+	if names == "" {
+		names = "ntfy"
+	}
+	log.Printf("- NOTIFY_CHANNELS: %s", names)
+
+	var channels []*notifierChannel
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		notifier, err := newNotifier(name)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %v", name, err)
+		}
+		channels = append(channels, &notifierChannel{
+			notifier: notifier,
+			stats:    NotifierStats{Name: notifier.Name()},
+		})
+	}
+
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no notification channels configured")
+	}
+	return channels, nil
+}
+
+// Install channels. A channel that was already configured keeps its counters
+// and queued jobs, only its backend settings change.
+func setNotifiers(channels []*notifierChannel) {
+	notifiersMu.Lock()
+	existing := make(map[string]*notifierChannel, len(notifiers))
+	for _, channel := range notifiers {
+		existing[channel.stats.Name] = channel
+	}
+	for i, channel := range channels {
+		if old, ok := existing[channel.stats.Name]; ok {
+			old.mu.Lock()
+			old.notifier = channel.notifier
+			old.mu.Unlock()
+			channels[i] = old
+		}
+	}
+	notifiers = channels
+	notifiersMu.Unlock()
+
+	// Probe the new backends on the next readiness check
+	notifierProbesMu.Lock()
+	notifierProbes = nil
+	notifierProbesMu.Unlock()
+}
+
+// Create a notifier of the given type from its settings
+func newNotifier(kind string) (Notifier, error) {
+	switch kind {
+	case "ntfy":
+		topic, err := requireSetting("NTFY_TOPIC")
+		if err != nil {
+			return nil, err
+		}
+		server := setting("NTFY_SERVER")
+		if server == "" {
+			server = "https://ntfy.sh"
+		}
+		return &ntfyNotifier{
+			server:    strings.TrimRight(server, "/"),
+			topic:     topic,
+			token:     setting("NTFY_TOKEN"),
+			username:  setting("NTFY_USERNAME"),
+			password:  setting("NTFY_PASSWORD"),
+			publicURL: strings.TrimRight(setting("PUBLIC_URL"), "/"),
+		}, nil
+	case "discord":
+		webhookURL, err := requireSetting("DISCORD_WEBHOOK_URL")
+		if err != nil {
+			return nil, err
+		}
+		return &discordNotifier{webhookURL: webhookURL}, nil
+	case "telegram":
+		token, err := requireSetting("TELEGRAM_BOT_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		chatID, err := requireSetting("TELEGRAM_CHAT_ID")
+		if err != nil {
+			return nil, err
+		}
+		return &telegramNotifier{token: token, chatID: chatID}, nil
+	case "email":
+		host, err := requireSetting("SMTP_HOST")
+		if err != nil {
+			return nil, err
+		}
+		from, err := requireSetting("SMTP_FROM")
+		if err != nil {
+			return nil, err
+		}
+		to, err := requireSetting("SMTP_TO")
+		if err != nil {
+			return nil, err
+		}
+		var recipients []string
+		for _, addr := range strings.Split(to, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				recipients = append(recipients, addr)
+			}
+		}
+		if len(recipients) == 0 {
+			return nil, fmt.Errorf("SMTP_TO has no addresses")
+		}
+		port := setting("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &emailNotifier{
+			addr:     host + ":" + port,
+			host:     host,
+			username: setting("SMTP_USERNAME"),
+			password: setting("SMTP_PASSWORD"),
+			from:     from,
+			to:       recipients,
+		}, nil
+	case "webhook":
+		url, err := requireSetting("WEBHOOK_URL")
+		if err != nil {
+			return nil, err
+		}
+		return &webhookNotifier{url: url}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type")
+}
+
+func requireSetting(key string) (string, error) {
+	value := setting(key)
+	if value == "" {
+		return "", fmt.Errorf("%s is required", key)
+	}
+	return value, nil
+}
+
+// Snooze state, set through /api/snooze
+var (
+	snoozedUntil time.Time
+	snoozeMu     sync.Mutex
+)
+
+func snooze(d time.Duration) time.Time {
+	snoozeMu.Lock()
+	defer snoozeMu.Unlock()
+	snoozedUntil = time.Now().Add(d)
+	return snoozedUntil
+}
+
+// Return the end of the active snooze, zero if not snoozed
+func snoozeEnd() time.Time {
+	snoozeMu.Lock()
+	defer snoozeMu.Unlock()
+	if time.Now().After(snoozedUntil) {
+		return time.Time{}
+	}
+	return snoozedUntil
+}
+
+// Queue notification for delivery to every configured channel
+func sendNotification(n Notification) error {
+	// New stock always gets through, everything else waits out the snooze
+	if n.Event != EventStockIn && !snoozeEnd().IsZero() {
+		log.Printf("Notification %q suppressed while snoozed", n.Title)
+		return nil
+	}
+
+	// The first matching alert rule can reroute, reword or drop it
+	n, channels := applyRules(n, currentNotifiers())
+
+	// Queue one job per channel, the dispatcher retries each independently
+	var errs []error
+	for _, channel := range channels {
+		if err := dispatcher.Enqueue(channel, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handle POST /api/snooze?duration=1h and DELETE /api/snooze
+func handleSnooze(w http.ResponseWriter, r *http.Request) {
+	var until time.Time
+	switch r.Method {
+	case http.MethodPost:
+		duration := time.Hour
+		if value := r.URL.Query().Get("duration"); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+			duration = d
+		}
+		until = snooze(duration)
+		log.Printf("Notifications snoozed until %s", until.Format("15:04:05"))
+	case http.MethodDelete:
+		snooze(0)
+		log.Printf("Snooze cleared")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"snoozed_until": until})
+}
+
+func notifierStats() []NotifierStats {
+	channels := currentNotifiers()
+	stats := make([]NotifierStats, 0, len(channels))
+	for _, channel := range channels {
+		channel.mu.Lock()
+		stats = append(stats, channel.stats)
+		channel.mu.Unlock()
+	}
+	return stats
+}
+
+// Post body to url and check for a 2xx response
+func postNotification(ctx context.Context, url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Append the URL to the body for channels without a link field
+func bodyWithURL(n Notification) string {
+	if n.URL == "" || strings.Contains(n.Body, n.URL) {
+		return n.Body
+	}
+	return n.Body + "\n" + n.URL
+}
+
+type ntfyNotifier struct {
+	server    string
+	topic     string
+	token     string
+	username  string
+	password  string
+	publicURL string // Tracker URL reachable from the phone, enables the snooze action
+}
+
+// Emoji tags shown by ntfy for each event type
+var ntfyEventTags = map[string]string{
+	EventStockIn:        "rotating_light",
+	EventStockReminder:  "bell",
+	EventStockOut:       "x",
+	EventSKUChanged:     "arrows_counterclockwise",
+	EventErrorThreshold: "warning",
+	EventReport:         "bar_chart",
+	EventStartup:        "rocket",
+	EventShutdown:       "stop_sign",
+}
+
+func (n *ntfyNotifier) Name() string { return "ntfy" }
+
+func (n *ntfyNotifier) Addr() string { return urlAddr(n.server) }
+
+func (n *ntfyNotifier) Send(ctx context.Context, msg Notification) error {
+	header := http.Header{}
+	header.Set("Title", msg.Title)
+	header.Set("Priority", fmt.Sprintf("%d", msg.Priority))
+
+	tags := msg.Tags
+	if emoji, ok := ntfyEventTags[msg.Event]; ok {
+		tags = append([]string{emoji}, tags...)
+	}
+	if len(tags) > 0 {
+		header.Set("Tags", strings.Join(tags, ","))
+	}
+	if msg.Markdown {
+		header.Set("Markdown", "yes")
+	}
+
+	var actions []string
+	if msg.URL != "" {
+		header.Set("Click", msg.URL)
+		actions = append(actions, fmt.Sprintf("view, Open store, %s, clear=true", msg.URL))
+	}
+	if n.publicURL != "" && (msg.Event == EventStockIn || msg.Event == EventStockReminder) {
+		actions = append(actions, fmt.Sprintf("http, Snooze 1h, %s/api/snooze?duration=1h, method=POST, clear=true", n.publicURL))
+	}
+	if len(actions) > 0 {
+		header.Set("Actions", strings.Join(actions, "; "))
+	}
+
+	switch {
+	case n.token != "":
+		header.Set("Authorization", "Bearer "+n.token)
+	case n.username != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(n.username + ":" + n.password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	ntfyURL := fmt.Sprintf("%s/%s", n.server, n.topic)
+	return postNotification(ctx, ntfyURL, "text/plain", []byte(msg.Body), header)
+}
+
+type discordNotifier struct {
+	webhookURL string
+}
+
+func (d *discordNotifier) Name() string { return "discord" }
+
+func (d *discordNotifier) Addr() string { return urlAddr(d.webhookURL) }
+
+func (d *discordNotifier) Send(ctx context.Context, n Notification) error {
+	// Map priority to an embed color from grey to red
+	colors := map[int]int{1: 0x95a5a6, 2: 0x95a5a6, 3: 0x3498db, 4: 0xe67e22, 5: 0xe74c3c}
+
+	payload := map[string]interface{}{
+		"embeds": []map[string]interface{}{{
+			"title":       n.Title,
+			"description": n.Body,
+			"url":         n.URL,
+			"color":       colors[n.Priority],
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, d.webhookURL, "application/json", body, nil)
+}
+
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (t *telegramNotifier) Name() string { return "telegram" }
+
+func (t *telegramNotifier) Addr() string { return "api.telegram.org:443" }
+
+func (t *telegramNotifier) Send(ctx context.Context, n Notification) error {
+	payload := map[string]interface{}{
+		"chat_id":              t.chatID,
+		"text":                 n.Title + "\n\n" + bodyWithURL(n),
+		"disable_notification": n.Priority <= 2,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.token)
+	return postNotification(ctx, url, "application/json", body, nil)
+}
+
+type emailNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (e *emailNotifier) Name() string { return "email" }
+
+func (e *emailNotifier) Addr() string { return e.addr }
+
+func (e *emailNotifier) Send(ctx context.Context, n Notification) error {
+	var auth smtp.Auth
+	if e.username != "" {
+		auth = smtp.PlainAuth("", e.username, e.password, e.host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [FE-Tracker] %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		e.from, strings.Join(e.to, ", "), n.Title, bodyWithURL(n))
+
+	// net/smtp has no context support, run it so ctx can still cut the wait short
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(e.addr, auth, e.from, e.to, []byte(msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// webhookNotifier posts the notification as JSON to a generic endpoint
+type webhookNotifier struct {
+	url string
+}
+
+func (wh *webhookNotifier) Name() string { return "webhook" }
+
+func (wh *webhookNotifier) Addr() string { return urlAddr(wh.url) }
+
+func (wh *webhookNotifier) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, wh.url, "application/json", body, nil)
+}
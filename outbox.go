@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// One line of the outbox journal. An "add" is written before a notification
+// is queued and a "done" once its channel acknowledged it.
+type outboxRecord struct {
+	Op           string        `json:"op"`
+	ID           uint64        `json:"id"`
+	Channel      string        `json:"channel,omitempty"`
+	Queued       time.Time     `json:"queued,omitempty"`
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+// Outbox is an append-only, file-backed journal of undelivered notifications
+type Outbox struct {
+	path string
+	file *os.File
+	open map[uint64]bool // IDs added but not yet done
+	mu   sync.Mutex
+}
+
+// Open the journal in dir, returning the notifications that were never
+// delivered in sorted order. The file is compacted to just those entries.
+func openOutbox(dir string) (*Outbox, []outboxRecord, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("creating data dir: %v", err)
+	}
+	path := filepath.Join(dir, "outbox.jsonl")
+
+	pending, err := readOutbox(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rewrite the journal with only the pending entries
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compacting outbox: %v", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, record := range pending {
+		if err := enc.Encode(record); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("compacting outbox: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, nil, fmt.Errorf("compacting outbox: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, nil, fmt.Errorf("compacting outbox: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening outbox: %v", err)
+	}
+
+	o := &Outbox{path: path, file: file, open: make(map[uint64]bool)}
+	for _, record := range pending {
+		o.open[record.ID] = true
+	}
+	return o, pending, nil
+}
+
+// Read the journal and return adds without a matching done
+func readOutbox(path string) ([]outboxRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading outbox: %v", err)
+	}
+	defer f.Close()
+
+	var order []uint64
+	adds := make(map[uint64]outboxRecord)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var record outboxRecord
+			if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+				// A torn last line from a crash, skip it
+				log.Printf("Skipping corrupt outbox entry: %v", jsonErr)
+			} else if record.Op == "add" && record.Notification != nil {
+				adds[record.ID] = record
+				order = append(order, record.ID)
+			} else if record.Op == "done" {
+				delete(adds, record.ID)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading outbox: %v", err)
+		}
+	}
+
+	pending := make([]outboxRecord, 0, len(adds))
+	for _, id := range order {
+		if record, ok := adds[id]; ok {
+			pending = append(pending, record)
+			delete(adds, id)
+		}
+	}
+	return pending, nil
+}
+
+func (o *Outbox) write(record outboxRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing outbox: %v", err)
+	}
+	return o.file.Sync()
+}
+
+// Add journals a job before it is queued
+func (o *Outbox) Add(job *notifyJob) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := job.n
+	o.open[job.id] = true
+	return o.write(outboxRecord{
+		Op:           "add",
+		ID:           job.id,
+		Channel:      job.channel.stats.Name,
+		Queued:       job.queued,
+		Notification: &n,
+	})
+}
+
+// Done marks a job delivered, truncating the journal once nothing is open
+func (o *Outbox) Done(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.open, id)
+	if len(o.open) == 0 {
+		if err := o.file.Truncate(0); err != nil {
+			return fmt.Errorf("truncating outbox: %v", err)
+		}
+		return nil
+	}
+	return o.write(outboxRecord{Op: "done", ID: id})
+}
+
+// Stock alerts that are too old to act on are dropped on replay
+func isStockEvent(event string) bool {
+	return event == EventStockIn || event == EventStockReminder || event == EventStockOut
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProductInfo holds the catalog details of the tracked product
+type ProductInfo struct {
+	ProductID string  `json:"product_id,omitempty"` // Marketplace product ID
+	Name      string  `json:"name,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
+	ImageURL  string  `json:"image_url,omitempty"`
+	Retailer  string  `json:"retailer,omitempty"`
+}
+
+// PricePoint is one entry of the price history of a target
+type PricePoint struct {
+	SKU      string    `json:"sku"`
+	Price    float64   `json:"price"`
+	Currency string    `json:"currency"`
+	Time     time.Time `json:"time"`
+}
+
+// PriceChange is published on /events when the price of a target changes
+type PriceChange struct {
+	Target   string    `json:"target"`
+	SKU      string    `json:"sku"`
+	OldPrice float64   `json:"old_price,omitempty"` // Zero for the first known price
+	NewPrice float64   `json:"new_price"`
+	Currency string    `json:"currency"`
+	Time     time.Time `json:"time"`
+}
+
+// Limit price history per target
+const maxPricePoints = 50
+
+// Currency of the marketplace region, the second part of the locale
+var regionCurrencies = map[string]string{
+	"us": "USD", "gb": "GBP", "ca": "CAD", "au": "AUD", "ch": "CHF", "pl": "PLN",
+	"se": "SEK", "dk": "DKK", "no": "NOK", "cz": "CZK", "jp": "JPY", "kr": "KRW",
+	"de": "EUR", "at": "EUR", "fr": "EUR", "be": "EUR", "nl": "EUR", "lu": "EUR", "it": "EUR",
+	"es": "EUR", "pt": "EUR", "fi": "EUR", "ie": "EUR", "gr": "EUR", "sk": "EUR", "si": "EUR",
+}
+
+// Currency symbols in formatted catalog prices, for unknown regions
+var currencySymbols = map[string]string{"€": "EUR", "£": "GBP", "$": "USD", "zł": "PLN", "kr": "SEK", "Kč": "CZK"}
+
+func localeCurrency(locale string, formatted string) string {
+	_, region, _ := strings.Cut(locale, "-")
+	if currency, ok := regionCurrencies[region]; ok {
+		return currency
+	}
+	for symbol, currency := range currencySymbols {
+		if strings.Contains(formatted, symbol) {
+			return currency
+		}
+	}
+	return ""
+}
+
+// Parse a price like "2329.00", "€2,329.00" or "2.329,00 €", zero if it
+// can't be parsed
+func parsePrice(value string) float64 {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '.' || r == ',' {
+			return r
+		}
+		return -1
+	}, value)
+
+	// The last separator is the decimal one if two digits or fewer follow
+	if i := strings.LastIndexAny(digits, ".,"); i >= 0 && len(digits)-i-1 <= 2 {
+		digits = strings.NewReplacer(".", "", ",", "").Replace(digits[:i]) + "." + digits[i+1:]
+	} else {
+		digits = strings.NewReplacer(".", "", ",", "").Replace(digits)
+	}
+
+	price, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0
+	}
+	return price
+}
+
+func formatPrice(price float64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", price, currency))
+}
+
+// Update the catalog details from the search result of the tracked product.
+// Its price is only used until the inventory reports one.
+func (m *Monitor) updateProduct(p SearchProduct) {
+	m.mu.Lock()
+	m.product.ProductID = p.ProductID.String()
+	m.product.Name = p.DisplayName
+	m.product.ImageURL = p.ImageURL
+	if len(p.Retailers) > 0 {
+		m.product.Retailer = p.Retailers[0].RetailerName
+	}
+	known := m.product.Price > 0
+	m.mu.Unlock()
+
+	if !known {
+		m.observePrice(p.ProductSKU, parsePrice(p.ProductPrice), p.ProductPrice)
+	}
+}
+
+// Record the current price of sku, keeping a history of changes
+func (m *Monitor) observePrice(sku string, price float64, formatted string) {
+	if price <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	old := m.product.Price
+	if old == price {
+		m.mu.Unlock()
+		return
+	}
+	if m.product.Currency == "" {
+		m.product.Currency = localeCurrency(m.Target.Locale, formatted)
+	}
+	m.product.Price = price
+	change := PriceChange{
+		Target:   m.Target.ID,
+		SKU:      sku,
+		OldPrice: old,
+		NewPrice: price,
+		Currency: m.product.Currency,
+		Time:     time.Now(),
+	}
+	m.prices = append(m.prices, PricePoint{SKU: sku, Price: price, Currency: change.Currency, Time: change.Time})
+	if len(m.prices) > maxPricePoints {
+		m.prices = m.prices[len(m.prices)-maxPricePoints:]
+	}
+	m.mu.Unlock()
+
+	details := map[string]string{
+		"price":    strconv.FormatFloat(price, 'f', 2, 64),
+		"currency": change.Currency,
+	}
+	if old > 0 {
+		details["old_price"] = strconv.FormatFloat(old, 'f', 2, 64)
+		log.Printf("[%s] Price changed from %s to %s", m.Target.ID,
+			formatPrice(old, change.Currency), formatPrice(price, change.Currency))
+	}
+	store.Record(StoredEvent{
+		Type:    StoredPriceChanged,
+		Time:    change.Time,
+		Target:  m.Target.ID,
+		SKU:     sku,
+		Details: details,
+	})
+	publishEvent(SSEPriceChanged, m.Target.ID, change)
+}
+
+// Restore the price history of m from the event store, so a restart doesn't
+// record the current price as a change
+func (m *Monitor) loadPriceHistory() {
+	for _, event := range store.Since(StoredPriceChanged, time.Time{}) {
+		if event.Target != m.Target.ID {
+			continue
+		}
+		price, _ := strconv.ParseFloat(event.Details["price"], 64)
+		point := PricePoint{SKU: event.SKU, Price: price, Currency: event.Details["currency"], Time: event.Time}
+		m.prices = append(m.prices, point)
+		m.product.Price = point.Price
+		m.product.Currency = point.Currency
+	}
+	if len(m.prices) > maxPricePoints {
+		m.prices = m.prices[len(m.prices)-maxPricePoints:]
+	}
+}
+
+// Product details added to stored stock and SKU events
+func (m *Monitor) productDetails(details map[string]string) map[string]string {
+	m.mu.Lock()
+	p := m.product
+	m.mu.Unlock()
+
+	if details == nil {
+		details = make(map[string]string)
+	}
+	if p.Price > 0 {
+		details["price"] = strconv.FormatFloat(p.Price, 'f', 2, 64)
+	}
+	for key, value := range map[string]string{
+		"currency":   p.Currency,
+		"product_id": p.ProductID,
+		"image_url":  p.ImageURL,
+		"retailer":   p.Retailer,
+	} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	return details
+}
+
+// Price line for notifications, empty while unknown
+func (m *Monitor) priceLine() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.product.Price <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n- Price: %s", formatPrice(m.product.Price, m.product.Currency))
+}
+
+// Stock event for /events with the current price
+func (m *Monitor) stockEvent(sku, purchaseURL string, lasted time.Duration) StockEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return StockEvent{
+		Target:          m.Target.ID,
+		SKU:             sku,
+		PurchaseURL:     purchaseURL,
+		Price:           m.product.Price,
+		Currency:        m.product.Currency,
+		DurationSeconds: lasted.Seconds(),
+		Time:            time.Now(),
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Upstream endpoints, used as the endpoint label
+const (
+	EndpointSearch      = "search"
+	EndpointFeInventory = "feinventory"
+)
+
+// StatusError is returned when an upstream API answers with a non-200 status
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status: %d", e.Endpoint, e.StatusCode)
+}
+
+// counterVec is a minimal Prometheus counter with labels
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // Keyed by label values joined with \xff
+	mu     sync.Mutex
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(key, "\xff")), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a minimal Prometheus histogram with labels
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+	mu      sync.Mutex
+}
+
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		values := strings.Split(key, "\xff")
+		labels := append(append([]string{}, h.labels...), "le")
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, withLabel(values, formatFloat(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, withLabel(values, "+Inf")), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), series.count)
+	}
+}
+
+// Copy values with one more label value appended
+func withLabel(values []string, value string) []string {
+	return append(append([]string{}, values...), value)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%s", name, strconv.Quote(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Exported metrics
+var (
+	upstreamRequests = newCounterVec("fetracker_upstream_requests_total",
+		"Upstream API requests by endpoint and status code.", "endpoint", "code")
+	upstreamDuration = newHistogramVec("fetracker_upstream_request_duration_seconds",
+		"Upstream API request latency.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "endpoint")
+	errorsByClass = newCounterVec("fetracker_errors_total",
+		"Errors by class.", "class")
+)
+
+// Classify err for the errors_total metric
+func classifyError(err error) string {
+	var statusErr *StatusError
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		case statusErr.StatusCode == http.StatusForbidden:
+			return "forbidden"
+		case statusErr.StatusCode >= 500:
+			return "http_5xx"
+		default:
+			return "http_4xx"
+		}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return "decode"
+	}
+	return "other"
+}
+
+// Handle GET /metrics in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	upstreamRequests.write(w)
+	upstreamDuration.write(w)
+	proxyLatency.write(w)
+	errorsByClass.write(w)
+
+	stats := notifierStats()
+	fmt.Fprint(w, "# HELP fetracker_notifications_sent_total Notifications delivered per channel.\n# TYPE fetracker_notifications_sent_total counter\n")
+	for _, channel := range stats {
+		fmt.Fprintf(w, "fetracker_notifications_sent_total%s %d\n", formatLabels([]string{"channel"}, []string{channel.Name}), channel.Sent)
+	}
+	fmt.Fprint(w, "# HELP fetracker_notifications_failed_total Failed notification attempts per channel.\n# TYPE fetracker_notifications_failed_total counter\n")
+	for _, channel := range stats {
+		fmt.Fprintf(w, "fetracker_notifications_failed_total%s %d\n", formatLabels([]string{"channel"}, []string{channel.Name}), channel.Failed)
+	}
+
+	fmt.Fprint(w, "# HELP fetracker_in_stock Whether the current SKU of a target is in stock.\n# TYPE fetracker_in_stock gauge\n")
+	for _, target := range scheduler.targetStatuses() {
+		if target.CurrentSKU == "" {
+			continue
+		}
+		inStock := 0
+		if target.InStock {
+			inStock = 1
+		}
+		fmt.Fprintf(w, "fetracker_in_stock%s %d\n", formatLabels([]string{"target", "sku"}, []string{target.ID, target.CurrentSKU}), inStock)
+	}
+
+	clients, dropped := hub.Stats()
+	fmt.Fprintf(w, "# HELP fetracker_sse_clients Connected /events clients.\n# TYPE fetracker_sse_clients gauge\nfetracker_sse_clients %d\n", clients)
+	fmt.Fprintf(w, "# HELP fetracker_sse_dropped_clients_total /events clients dropped for falling behind.\n# TYPE fetracker_sse_dropped_clients_total counter\nfetracker_sse_dropped_clients_total %d\n", dropped)
+
+	fmt.Fprintf(w, "# HELP fetracker_backoff_factor Multiplier applied to the polling intervals after upstream push back.\n# TYPE fetracker_backoff_factor gauge\nfetracker_backoff_factor %d\n",
+		upstreamBackoff.Factor())
+
+	metrics.mu.Lock()
+	lastSuccess := metrics.LastSuccess
+	metrics.mu.Unlock()
+	lastSuccessSeconds := 0.0
+	if !lastSuccess.IsZero() {
+		lastSuccessSeconds = float64(lastSuccess.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(w, "# HELP fetracker_last_successful_check_timestamp_seconds Unix time of the last successful upstream request.\n# TYPE fetracker_last_successful_check_timestamp_seconds gauge\nfetracker_last_successful_check_timestamp_seconds %s\n",
+		formatFloat(lastSuccessSeconds))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy pool defaults
+const (
+	DEFAULT_PROXY_ROTATION     = "round-robin"
+	DEFAULT_PROXY_MAX_FAILURES = 3
+	DEFAULT_PROXY_QUARANTINE   = "300000" // Milliseconds a failing proxy is skipped
+)
+
+// upstreamProxy is one proxy of the pool with its own client and stats
+type upstreamProxy struct {
+	name             string // scheme://host, without credentials
+	client           *http.Client
+	requests         int
+	failures         int
+	consecutive      int // Failures in a row, quarantines at maxFailures
+	totalLatency     time.Duration
+	quarantinedUntil time.Time
+}
+
+// ProxyStats is the per-proxy view exposed in /status
+type ProxyStats struct {
+	Proxy            string     `json:"proxy"`
+	Requests         int        `json:"requests"`
+	Failures         int        `json:"failures"`
+	AvgLatencyMs     int64      `json:"avg_latency_ms"`
+	QuarantinedUntil *time.Time `json:"quarantined_until,omitempty"`
+}
+
+// ProxyPool rotates upstream requests through the configured proxies,
+// skipping proxies that are quarantined after repeated failures
+type ProxyPool struct {
+	proxies     []*upstreamProxy
+	random      bool
+	next        int
+	maxFailures int
+	quarantine  time.Duration
+	mu          sync.Mutex
+}
+
+// Nil while upstream requests go direct
+var proxyPool *ProxyPool
+
+var proxyLatency = newHistogramVec("fetracker_proxy_request_duration_seconds",
+	"Upstream request latency per proxy.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "proxy", "result")
+
+// Build a client that sends its requests through proxyURL
+func newProxyClient(proxyURL *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Timeout: client.Timeout, Transport: transport}
+}
+
+// Parse a proxy URL, accepting http, https, socks5 and socks5h
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+	}
+	return u, nil
+}
+
+// Read UPSTREAM_PROXIES, PROXY_ROTATION, PROXY_MAX_FAILURES and
+// PROXY_QUARANTINE. Returns nil without proxies.
+func loadProxyPool() (*ProxyPool, error) {
+	raw := setting("UPSTREAM_PROXIES")
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	rotation := setting("PROXY_ROTATION")
+	if rotation == "" {
+		rotation = DEFAULT_PROXY_ROTATION
+	}
+	if rotation != "round-robin" && rotation != "random" {
+		return nil, fmt.Errorf("invalid PROXY_ROTATION %q, use round-robin or random", rotation)
+	}
+
+	quarantineValue := setting("PROXY_QUARANTINE")
+	if quarantineValue == "" {
+		quarantineValue = DEFAULT_PROXY_QUARANTINE
+	}
+	quarantine, err := parseInterval(quarantineValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy quarantine: %v", err)
+	}
+
+	pool := &ProxyPool{
+		random:      rotation == "random",
+		maxFailures: envInt("PROXY_MAX_FAILURES", DEFAULT_PROXY_MAX_FAILURES),
+		quarantine:  quarantine,
+	}
+	for _, field := range fields {
+		u, err := parseProxyURL(field)
+		if err != nil {
+			return nil, err
+		}
+		pool.proxies = append(pool.proxies, &upstreamProxy{
+			name:   u.Scheme + "://" + u.Host,
+			client: newProxyClient(u),
+		})
+	}
+
+	log.Printf("- UPSTREAM_PROXIES: %d proxies, %s rotation, quarantine %v after %d failures",
+		len(pool.proxies), rotation, quarantine, pool.maxFailures)
+	return pool, nil
+}
+
+// Pick returns the next proxy that isn't quarantined. If all are, the one
+// whose quarantine ends first is used so requests never go direct.
+func (p *ProxyPool) Pick() *upstreamProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	n := len(p.proxies)
+	start := p.next
+	if p.random {
+		start = rand.Intn(n)
+	}
+
+	var fallback *upstreamProxy
+	for i := 0; i < n; i++ {
+		proxy := p.proxies[(start+i)%n]
+		if !now.Before(proxy.quarantinedUntil) {
+			p.next = (start + i + 1) % n
+			return proxy
+		}
+		if fallback == nil || proxy.quarantinedUntil.Before(fallback.quarantinedUntil) {
+			fallback = proxy
+		}
+	}
+	return fallback
+}
+
+// Record the result of a request through proxy. Transport errors and
+// blocked responses count as failures.
+func (p *ProxyPool) Record(proxy *upstreamProxy, resp *http.Response, err error, latency time.Duration) {
+	failed := err != nil || resp.StatusCode == http.StatusForbidden ||
+		resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusProxyAuthRequired
+	result := "ok"
+	if failed {
+		result = "failed"
+	}
+	proxyLatency.Observe(latency.Seconds(), proxy.name, result)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxy.requests++
+	proxy.totalLatency += latency
+	if !failed {
+		proxy.consecutive = 0
+		return
+	}
+
+	proxy.failures++
+	proxy.consecutive++
+	if proxy.consecutive >= p.maxFailures {
+		proxy.consecutive = 0
+		proxy.quarantinedUntil = time.Now().Add(p.quarantine)
+		log.Printf("Proxy %s failed %d times in a row, quarantined for %v", proxy.name, p.maxFailures, p.quarantine)
+	}
+}
+
+// Do sends req through the next proxy
+func (p *ProxyPool) Do(req *http.Request) (*http.Response, error) {
+	proxy := p.Pick()
+	start := time.Now()
+	resp, err := proxy.client.Do(req)
+	p.Record(proxy, resp, err, time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("via proxy %s: %w", proxy.name, err)
+	}
+	return resp, nil
+}
+
+// Stats returns the per-proxy counters, nil-safe
+func (p *ProxyPool) Stats() []ProxyStats {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]ProxyStats, 0, len(p.proxies))
+	for _, proxy := range p.proxies {
+		s := ProxyStats{Proxy: proxy.name, Requests: proxy.requests, Failures: proxy.failures}
+		if proxy.requests > 0 {
+			s.AvgLatencyMs = (proxy.totalLatency / time.Duration(proxy.requests)).Milliseconds()
+		}
+		if now.Before(proxy.quarantinedUntil) {
+			until := proxy.quarantinedUntil
+			s.QuarantinedUntil = &until
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// host:port of NOTIFY_PROXY, probed instead of the HTTP notifier backends
+var notifyProxyAddr string
+
+// Route notifications through NOTIFY_PROXY if set, they never use the
+// upstream pool. Email is always sent direct.
+func loadNotifyProxy() error {
+	raw := setting("NOTIFY_PROXY")
+	if raw == "" {
+		return nil
+	}
+	u, err := parseProxyURL(raw)
+	if err != nil {
+		return err
+	}
+	notifyClient = newProxyClient(u)
+	notifyClient.Timeout = notifyTimeout
+	notifyProxyAddr = urlAddr(u.String())
+	log.Printf("- NOTIFY_PROXY: %s://%s", u.Scheme, u.Host)
+	return nil
+}
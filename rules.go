@@ -0,0 +1,791 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// RuleSpec is an unparsed [rule.NAME] section of the config file
+type RuleSpec struct {
+	Name   string
+	Line   int
+	Values map[string]string
+}
+
+// Keys allowed in a [rule.NAME] section
+var ruleKeys = map[string]bool{
+	"when": true, "channels": true, "priority": true, "title": true, "template": true, "drop": true,
+}
+
+// Rule overrides how matching notifications are delivered. Rules are tried
+// in config file order and the first match wins.
+type Rule struct {
+	Name     string   `json:"name"`
+	When     string   `json:"when,omitempty"`     // Empty matches every notification
+	Channels []string `json:"channels,omitempty"` // Empty keeps all channels
+	Priority int      `json:"priority,omitempty"` // Zero keeps the event priority
+	Title    string   `json:"title,omitempty"`
+	Template string   `json:"template,omitempty"`
+	Drop     bool     `json:"drop,omitempty"`
+
+	cond  ruleExpr
+	title *template.Template
+	body  *template.Template
+}
+
+var (
+	rules   []*Rule
+	rulesMu sync.RWMutex
+)
+
+func currentRules() []*Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules
+}
+
+func setRules(compiled []*Rule) {
+	rulesMu.Lock()
+	rules = compiled
+	rulesMu.Unlock()
+	if len(compiled) > 0 {
+		log.Printf("- Alert rules: %d", len(compiled))
+	}
+}
+
+// Compile the rules from the config file at startup
+func loadRules() error {
+	compiled, err := compileRules(configRules(), currentNotifiers())
+	if err != nil {
+		return err
+	}
+	setRules(compiled)
+	return nil
+}
+
+// Compile rule sections, checking channels against the configured ones
+func compileRules(specs []RuleSpec, channels []*notifierChannel) ([]*Rule, error) {
+	configured := make(map[string]bool)
+	for _, channel := range channels {
+		configured[channel.stats.Name] = true
+	}
+
+	compiled := make([]*Rule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := compileRule(spec, configured)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s (line %d): %v", spec.Name, spec.Line, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+func compileRule(spec RuleSpec, configured map[string]bool) (*Rule, error) {
+	rule := &Rule{
+		Name:     spec.Name,
+		When:     spec.Values["when"],
+		Title:    spec.Values["title"],
+		Template: spec.Values["template"],
+	}
+
+	var err error
+	if rule.cond, err = parseRuleExpr(rule.When); err != nil {
+		return nil, fmt.Errorf("when: %v", err)
+	}
+
+	for _, name := range strings.Split(spec.Values["channels"], ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !configured[name] {
+			return nil, fmt.Errorf("channel %s is not in NOTIFY_CHANNELS", name)
+		}
+		rule.Channels = append(rule.Channels, name)
+	}
+
+	if value := spec.Values["priority"]; value != "" {
+		if rule.Priority, err = strconv.Atoi(value); err != nil || rule.Priority < 1 || rule.Priority > 5 {
+			return nil, fmt.Errorf("priority must be 1 to 5")
+		}
+	}
+	if value := spec.Values["drop"]; value != "" {
+		if rule.Drop, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("drop must be true or false")
+		}
+	}
+
+	if rule.Title != "" {
+		if rule.title, err = template.New("title").Option("missingkey=error").Parse(rule.Title); err != nil {
+			return nil, err
+		}
+	}
+	if rule.Template != "" {
+		if rule.body, err = template.New("template").Option("missingkey=error").Parse(rule.Template); err != nil {
+			return nil, err
+		}
+	}
+	return rule, nil
+}
+
+// Matches reports whether the rule applies to an event with vars
+func (r *Rule) Matches(vars ruleVars) bool {
+	return r.cond == nil || r.cond(vars).b
+}
+
+// Apply the rule to n, rendering the title and body templates
+func (r *Rule) Apply(n Notification, vars ruleVars) (Notification, error) {
+	if r.Priority > 0 {
+		n.Priority = r.Priority
+	}
+
+	data := make(map[string]interface{}, len(vars)+4)
+	for name, value := range vars {
+		if number, ok := value.(float64); ok && math.IsNaN(number) {
+			value = 0.0
+		}
+		data[name] = value
+	}
+	data["title"] = n.Title
+	data["body"] = n.Body
+	data["url"] = n.URL
+	data["price_text"] = ""
+	if price, _ := vars["price"].(float64); price > 0 {
+		data["price_text"] = formatPrice(price, vars["currency"].(string))
+	}
+
+	var buf bytes.Buffer
+	if r.title != nil {
+		if err := r.title.Execute(&buf, data); err != nil {
+			return n, err
+		}
+		n.Title = buf.String()
+	}
+	if r.body != nil {
+		buf.Reset()
+		if err := r.body.Execute(&buf, data); err != nil {
+			return n, err
+		}
+		n.Body = buf.String()
+	}
+	return n, nil
+}
+
+// First rule matching vars, nil if none does
+func matchRule(rules []*Rule, vars ruleVars) *Rule {
+	for _, rule := range rules {
+		if rule.Matches(vars) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Channels a rule delivers to, all channels if it names none
+func ruleChannels(rule *Rule, channels []*notifierChannel) []*notifierChannel {
+	if rule == nil || len(rule.Channels) == 0 {
+		return channels
+	}
+	var selected []*notifierChannel
+	for _, channel := range channels {
+		for _, name := range rule.Channels {
+			if channel.stats.Name == name {
+				selected = append(selected, channel)
+			}
+		}
+	}
+	return selected
+}
+
+// Apply the first matching rule to n. Returns the channels to deliver to,
+// none if the rule drops the notification.
+func applyRules(n Notification, channels []*notifierChannel) (Notification, []*notifierChannel) {
+	vars := notificationVars(n)
+	rule := matchRule(currentRules(), vars)
+	if rule == nil {
+		return n, channels
+	}
+	if rule.Drop {
+		log.Printf("Notification %q dropped by rule %s", n.Title, rule.Name)
+		return n, nil
+	}
+
+	applied, err := rule.Apply(n, vars)
+	if err != nil {
+		// A broken template shouldn't cost an alert, send the original
+		log.Printf("Rule %s: %v", rule.Name, err)
+		applied = n
+		if rule.Priority > 0 {
+			applied.Priority = rule.Priority
+		}
+	}
+	return applied, ruleChannels(rule, channels)
+}
+
+// Default priority of each notification event, for events from the store
+var eventPriorities = map[string]int{
+	EventStockIn:        5,
+	EventStockReminder:  5,
+	EventStockOut:       3,
+	EventSKUChanged:     4,
+	EventErrorThreshold: 4,
+	EventReport:         3,
+	EventStartup:        3,
+	EventShutdown:       3,
+}
+
+// ruleVars holds the variables of one event, strings or float64 numbers
+type ruleVars map[string]interface{}
+
+// Types of the variables available to rule expressions
+var ruleVariables = map[string]ruleType{
+	"event":    ruleString,
+	"target":   ruleString,
+	"locale":   ruleString,
+	"model":    ruleString,
+	"product":  ruleString,
+	"sku":      ruleString,
+	"currency": ruleString,
+	"title":    ruleString,
+	"time":     ruleString, // HH:MM
+	"weekday":  ruleString, // mon to sun
+	"price":    ruleNumber, // NaN until the price is known
+	"priority": ruleNumber,
+	"hour":     ruleNumber,
+}
+
+func baseVars(event, target, title string, priority int, at time.Time) ruleVars {
+	locale, _, _ := strings.Cut(target, "/")
+	vars := ruleVars{
+		"event":    event,
+		"target":   target,
+		"locale":   locale,
+		"model":    "",
+		"product":  "",
+		"sku":      "",
+		"currency": "",
+		"title":    title,
+		"time":     at.Format("15:04"),
+		"weekday":  strings.ToLower(at.Weekday().String()[:3]),
+		"price":    math.NaN(),
+		"priority": float64(priority),
+		"hour":     float64(at.Hour()),
+	}
+	if m := scheduler.monitor(target); m != nil {
+		vars["model"] = m.Target.GpuModel
+		vars["product"] = m.Target.Product
+	}
+	return vars
+}
+
+// Variables of a notification about to be sent, with the current product
+// details of its target
+func notificationVars(n Notification) ruleVars {
+	vars := baseVars(n.Event, n.Target, n.Title, n.Priority, time.Now())
+	if m := scheduler.monitor(n.Target); m != nil {
+		m.mu.Lock()
+		vars["sku"] = m.currentSKU
+		vars["currency"] = m.product.Currency
+		if m.product.Price > 0 {
+			vars["price"] = m.product.Price
+		}
+		m.mu.Unlock()
+	}
+	return vars
+}
+
+// Variables of a recorded event. Notification events use the event they
+// were sent for.
+func storedVars(e StoredEvent) ruleVars {
+	event, title := e.Type, ""
+	if e.Type == StoredNotification {
+		event, title = e.Details["event"], e.Message
+	}
+	vars := baseVars(event, e.Target, title, eventPriorities[event], e.Time.Local())
+	vars["sku"] = e.SKU
+	vars["currency"] = e.Details["currency"]
+	if price, err := strconv.ParseFloat(e.Details["price"], 64); err == nil && price > 0 {
+		vars["price"] = price
+	}
+	return vars
+}
+
+// RuleTestResult is the outcome of the rules for one recorded event
+type RuleTestResult struct {
+	Event    StoredEvent `json:"event"`
+	Matched  bool        `json:"matched"`
+	Rule     string      `json:"rule,omitempty"`
+	Drop     bool        `json:"drop,omitempty"`
+	Channels []string    `json:"channels,omitempty"`
+	Priority int         `json:"priority,omitempty"`
+	Title    string      `json:"title,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Handle GET /api/rules
+func handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loaded := currentRules()
+	if loaded == nil {
+		loaded = []*Rule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loaded)
+}
+
+// Handle GET /api/rules/test with the /api/history filters, limit and an
+// optional when expression to try instead of the configured rules
+func handleRulesTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	tested := currentRules()
+	if when := r.URL.Query().Get("when"); when != "" {
+		rule, err := compileRule(RuleSpec{Name: "when", Values: map[string]string{"when": when}}, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tested = []*Rule{rule}
+	}
+
+	// Newest events first, rollups aren't alerts
+	var events []StoredEvent
+	for _, event := range queryHistory(filter) {
+		if event.Type != StoredAPIRequests {
+			events = append(events, event)
+		}
+	}
+	total := len(events)
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+
+	results := make([]RuleTestResult, 0, len(events))
+	matched := 0
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		result := RuleTestResult{Event: event}
+		vars := storedVars(event)
+		if rule := matchRule(tested, vars); rule != nil {
+			matched++
+			result.Matched = true
+			result.Rule = rule.Name
+			result.Drop = rule.Drop
+			result.Channels = rule.Channels
+			n, err := rule.Apply(Notification{
+				Title:    vars["title"].(string),
+				Body:     event.Message,
+				Priority: eventPriorities[vars["event"].(string)],
+				Event:    vars["event"].(string),
+				Target:   event.Target,
+			}, vars)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			result.Priority, result.Title, result.Body = n.Priority, n.Title, n.Body
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total   int              `json:"total"`
+		Matched int              `json:"matched"`
+		Results []RuleTestResult `json:"results"`
+	}{total, matched, results})
+}
+
+// Rule expressions: comparisons of variables with literals, combined with
+// and, or, not and parentheses, e.g.
+//
+//	price <= 1200 and locale in [de-de, at-de] and time >= 08:00
+//
+// String comparisons with == and in ignore case, HH:MM times compare as
+// strings.
+
+type ruleType int
+
+const (
+	ruleBool ruleType = iota
+	ruleNumber
+	ruleString
+)
+
+func (t ruleType) String() string {
+	return [...]string{"boolean", "number", "string"}[t]
+}
+
+type ruleValue struct {
+	b   bool
+	num float64
+	str string
+}
+
+type ruleExpr func(vars ruleVars) ruleValue
+
+type ruleToken struct {
+	text string
+	kind byte // 'w' word, 's' quoted string, 'o' operator or punctuation
+}
+
+var (
+	ruleTimePattern = regexp.MustCompile(`^([0-9]{1,2}):([0-9]{2})$`)
+	ruleWordChars   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./:+-"
+)
+
+func lexRuleExpr(expr string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("()[],", c) >= 0:
+			tokens = append(tokens, ruleToken{string(c), 'o'})
+			i++
+		case strings.IndexByte("=!<>", c) >= 0:
+			op := string(c)
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unknown operator %s, use == or !=", op)
+			}
+			tokens = append(tokens, ruleToken{op, 'o'})
+			i += len(op)
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, ruleToken{expr[i+1 : i+1+end], 's'})
+			i += end + 2
+		case strings.IndexByte(ruleWordChars, c) >= 0:
+			start := i
+			for i < len(expr) && strings.IndexByte(ruleWordChars, expr[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, ruleToken{expr[start:i], 'w'})
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// Parse a rule expression, nil for an empty one
+func parseRuleExpr(expr string) (ruleExpr, error) {
+	tokens, err := lexRuleExpr(expr)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	e, t, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	if t != ruleBool {
+		return nil, fmt.Errorf("expression is a %s, not a condition", t)
+	}
+	return e, nil
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken {
+	if p.pos >= len(p.tokens) {
+		return ruleToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+// Consume the next token if it's the keyword or operator text
+func (p *ruleParser) accept(text string) bool {
+	token := p.peek()
+	if token.kind == 's' || !strings.EqualFold(token.text, text) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *ruleParser) or() (ruleExpr, ruleType, error) {
+	return p.chain("or", p.and, func(a, b bool) bool { return a || b })
+}
+
+func (p *ruleParser) and() (ruleExpr, ruleType, error) {
+	return p.chain("and", p.not, func(a, b bool) bool { return a && b })
+}
+
+// Parse operands of a binary keyword like and, combining them with join
+func (p *ruleParser) chain(keyword string, parse func() (ruleExpr, ruleType, error), join func(a, b bool) bool) (ruleExpr, ruleType, error) {
+	left, t, err := parse()
+	if err != nil || p.peek().kind == 's' || !strings.EqualFold(p.peek().text, keyword) {
+		return left, t, err
+	}
+	if t != ruleBool {
+		return nil, 0, fmt.Errorf("%s needs conditions, not a %s", keyword, t)
+	}
+	for p.accept(keyword) {
+		right, err := p.condition(parse)
+		if err != nil {
+			return nil, 0, err
+		}
+		l := left
+		left = func(v ruleVars) ruleValue { return ruleValue{b: join(l(v).b, right(v).b)} }
+	}
+	return left, ruleBool, nil
+}
+
+// Parse an operand of and, or and not, which has to be a condition
+func (p *ruleParser) condition(parse func() (ruleExpr, ruleType, error)) (ruleExpr, error) {
+	e, t, err := parse()
+	if err == nil && t != ruleBool {
+		err = fmt.Errorf("expected a condition, not a %s", t)
+	}
+	return e, err
+}
+
+func (p *ruleParser) not() (ruleExpr, ruleType, error) {
+	if p.accept("not") {
+		e, err := p.condition(p.not)
+		if err != nil {
+			return nil, 0, err
+		}
+		return func(v ruleVars) ruleValue { return ruleValue{b: !e(v).b} }, ruleBool, nil
+	}
+	return p.comparison()
+}
+
+func (p *ruleParser) comparison() (ruleExpr, ruleType, error) {
+	left, lt, err := p.operand()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if p.accept("in") {
+		return p.in(left, lt, false)
+	}
+	if p.peek().kind == 'w' && strings.EqualFold(p.peek().text, "not") &&
+		p.pos+1 < len(p.tokens) && strings.EqualFold(p.tokens[p.pos+1].text, "in") {
+		p.pos += 2
+		return p.in(left, lt, true)
+	}
+
+	op := p.peek().text
+	if p.peek().kind != 'o' || strings.IndexByte("=!<>", op[0]) < 0 {
+		return left, lt, nil
+	}
+	p.pos++
+	right, rt, err := p.operand()
+	if err != nil {
+		return nil, 0, err
+	}
+	if lt != rt {
+		return nil, 0, fmt.Errorf("can't compare %s with %s", lt, rt)
+	}
+	if lt == ruleBool && op != "==" && op != "!=" {
+		return nil, 0, fmt.Errorf("%s needs numbers or strings", op)
+	}
+
+	compare := func(v ruleVars) (int, bool) {
+		a, b := left(v), right(v)
+		switch lt {
+		case ruleNumber:
+			switch {
+			case math.IsNaN(a.num) || math.IsNaN(b.num):
+				return 0, false
+			case a.num < b.num:
+				return -1, true
+			case a.num > b.num:
+				return 1, true
+			}
+			return 0, true
+		case ruleString:
+			if op == "==" || op == "!=" {
+				if strings.EqualFold(a.str, b.str) {
+					return 0, true
+				}
+				return 1, true
+			}
+			return strings.Compare(a.str, b.str), true
+		}
+		if a.b == b.b {
+			return 0, true
+		}
+		return 1, true
+	}
+
+	var check func(int) bool
+	switch op {
+	case "==":
+		check = func(c int) bool { return c == 0 }
+	case "!=":
+		check = func(c int) bool { return c != 0 }
+	case "<":
+		check = func(c int) bool { return c < 0 }
+	case "<=":
+		check = func(c int) bool { return c <= 0 }
+	case ">":
+		check = func(c int) bool { return c > 0 }
+	case ">=":
+		check = func(c int) bool { return c >= 0 }
+	}
+	return func(v ruleVars) ruleValue {
+		c, ok := compare(v)
+		// An unknown price matches only !=
+		return ruleValue{b: ok && check(c) || !ok && op == "!="}
+	}, ruleBool, nil
+}
+
+// Parse the [a, b, ...] list of an in comparison
+func (p *ruleParser) in(left ruleExpr, lt ruleType, negate bool) (ruleExpr, ruleType, error) {
+	if lt == ruleBool {
+		return nil, 0, fmt.Errorf("in needs a number or string")
+	}
+	if !p.accept("[") {
+		return nil, 0, fmt.Errorf("expected [ after in")
+	}
+
+	var items []ruleValue
+	for !p.accept("]") {
+		if len(items) > 0 && !p.accept(",") {
+			return nil, 0, fmt.Errorf("expected , or ] in list")
+		}
+		token := p.peek()
+		switch token.kind {
+		case 0:
+			return nil, 0, fmt.Errorf("unexpected end of expression")
+		case 'o':
+			return nil, 0, fmt.Errorf("expected a list item, got %q", token.text)
+		}
+		p.pos++
+		item := ruleValue{str: ruleLiteralText(token)}
+		if lt == ruleNumber {
+			number, err := strconv.ParseFloat(token.text, 64)
+			if err != nil || token.kind == 's' {
+				return nil, 0, fmt.Errorf("%s is not a number", token.text)
+			}
+			item.num = number
+		}
+		items = append(items, item)
+	}
+
+	return func(v ruleVars) ruleValue {
+		value := left(v)
+		found := false
+		for _, item := range items {
+			if lt == ruleNumber && value.num == item.num || lt == ruleString && strings.EqualFold(value.str, item.str) {
+				found = true
+				break
+			}
+		}
+		return ruleValue{b: found != negate}
+	}, ruleBool, nil
+}
+
+// Text of a literal, with HH:MM times padded to compare with the time
+// variable
+func ruleLiteralText(token ruleToken) string {
+	if match := ruleTimePattern.FindStringSubmatch(token.text); match != nil && token.kind == 'w' {
+		hour, _ := strconv.Atoi(match[1])
+		return fmt.Sprintf("%02d:%s", hour, match[2])
+	}
+	return token.text
+}
+
+// Parse a literal, a variable or a parenthesized expression
+func (p *ruleParser) operand() (ruleExpr, ruleType, error) {
+	token := p.peek()
+	switch {
+	case token.kind == 0:
+		return nil, 0, fmt.Errorf("unexpected end of expression")
+	case token.kind == 's':
+		p.pos++
+		value := ruleValue{str: token.text}
+		return func(ruleVars) ruleValue { return value }, ruleString, nil
+	case token.text == "(":
+		p.pos++
+		e, t, err := p.or()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !p.accept(")") {
+			return nil, 0, fmt.Errorf("expected )")
+		}
+		return e, t, nil
+	case token.kind == 'o':
+		return nil, 0, fmt.Errorf("unexpected %s", token.text)
+	}
+	p.pos++
+
+	name := strings.ToLower(token.text)
+	if name == "true" || name == "false" {
+		value := ruleValue{b: name == "true"}
+		return func(ruleVars) ruleValue { return value }, ruleBool, nil
+	}
+	if ruleTimePattern.MatchString(token.text) {
+		value := ruleValue{str: ruleLiteralText(token)}
+		return func(ruleVars) ruleValue { return value }, ruleString, nil
+	}
+	if number, err := strconv.ParseFloat(token.text, 64); err == nil {
+		value := ruleValue{num: number}
+		return func(ruleVars) ruleValue { return value }, ruleNumber, nil
+	}
+
+	t, ok := ruleVariables[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown variable %s, quote strings outside lists", token.text)
+	}
+	if t == ruleNumber {
+		return func(v ruleVars) ruleValue {
+			number, _ := v[name].(float64)
+			return ruleValue{num: number}
+		}, t, nil
+	}
+	return func(v ruleVars) ruleValue {
+		str, _ := v[name].(string)
+		return ruleValue{str: str}
+	}, t, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Variables of a stock_in event for de-de/5090 at the given time of day
+func testRuleVars(clock string, price float64) ruleVars {
+	at, err := time.ParseInLocation("2006-01-02 15:04", "2026-10-16 "+clock, time.Local) // A Friday
+	if err != nil {
+		panic(err)
+	}
+	vars := baseVars(EventStockIn, "de-de/5090", "RTX 5090 In Stock", 5, at)
+	vars["model"] = "5090"
+	vars["product"] = "RTX 5090"
+	vars["sku"] = "PRO5090FE"
+	vars["currency"] = "EUR"
+	vars["price"] = price
+	return vars
+}
+
+func TestRuleExpr(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		expr  string
+		clock string
+		price float64
+		want  bool
+	}{
+		// Documented examples
+		{"price <= 1200 and locale in [de-de, at-de]", "12:00", 1150, true},
+		{"price <= 1200 and locale in [de-de, at-de]", "12:00", 1200, true},
+		{"price <= 1200 and locale in [de-de, at-de]", "12:00", 1200.01, false},
+		{"price <= 1200 and locale in [fr-fr, at-de]", "12:00", 1150, false},
+		{"time >= 08:00 and time <= 23:00", "07:59", 1150, false},
+		{"time >= 08:00 and time <= 23:00", "08:00", 1150, true},
+		{"time >= 08:00 and time <= 23:00", "23:00", 1150, true},
+		{"time >= 08:00 and time <= 23:00", "23:01", 1150, false},
+		{"time >= 8:00", "09:30", 1150, true},
+		{"time >= 23:00 or time < 08:00", "02:00", 1150, true},
+		{"time >= 23:00 or time < 08:00", "12:00", 1150, false},
+
+		// Unknown price: only != matches
+		{"price <= 1200", "12:00", nan, false},
+		{"price > 1200", "12:00", nan, false},
+		{"price == 1200", "12:00", nan, false},
+		{"price != 1200", "12:00", nan, true},
+		{"not price > 1200", "12:00", nan, true},
+		{"price in [1200, 1300]", "12:00", nan, false},
+
+		// Strings, lists and keywords
+		{"event == 'stock_in'", "12:00", 0, true},
+		{`event == "STOCK_IN"`, "12:00", 0, true},
+		{"event != 'stock_in'", "12:00", 0, false},
+		{"locale not in [fr-fr, it-it]", "12:00", 0, true},
+		{"locale NOT IN [DE-DE]", "12:00", 0, false},
+		{"model in [5080, 5090]", "12:00", 0, true},
+		{"product == 'rtx 5090' and sku == 'PRO5090FE'", "12:00", 0, true},
+		{"target == 'de-de/5090'", "12:00", 0, true},
+		{"priority in [4, 5] and hour == 12", "12:00", 0, true},
+		{"weekday in [sat, sun]", "12:00", 0, false},
+		{"weekday == 'fri'", "12:00", 0, true},
+		{"title == 'rtx 5090 in stock'", "12:00", 0, true},
+
+		// Precedence: not over and over or
+		{"true or false and false", "12:00", 0, true},
+		{"(true or false) and false", "12:00", 0, false},
+		{"not true or true", "12:00", 0, true},
+		{"not (true or true)", "12:00", 0, false},
+		{"true == false", "12:00", 0, false},
+		{"false", "12:00", 0, false},
+	}
+
+	for _, tt := range tests {
+		cond, err := parseRuleExpr(tt.expr)
+		if err != nil {
+			t.Errorf("parseRuleExpr(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := cond(testRuleVars(tt.clock, tt.price)).b; got != tt.want {
+			t.Errorf("%q at %s with price %v = %v, want %v", tt.expr, tt.clock, tt.price, got, tt.want)
+		}
+	}
+}
+
+func TestRuleExprEmpty(t *testing.T) {
+	cond, err := parseRuleExpr("  ")
+	if err != nil || cond != nil {
+		t.Fatalf("parseRuleExpr(blank) = %v, %v; want nil, nil", cond != nil, err)
+	}
+	rule, err := compileRule(RuleSpec{Name: "all"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Matches(testRuleVars("12:00", 0)) {
+		t.Error("rule without when doesn't match")
+	}
+}
+
+func TestRuleExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"price", "expression is a number, not a condition"},
+		{"locale", "expression is a string, not a condition"},
+		{"price = 1200", "unknown operator =, use == or !="},
+		{"!true", "unknown operator !, use == or !="},
+		{"stock == 1", "unknown variable stock"},
+		{"event == stock_in", "unknown variable stock_in, quote strings outside lists"},
+		{"price <= 'cheap'", "can't compare number with string"},
+		{"event < true", "can't compare string with boolean"},
+		{"true < false", "< needs numbers or strings"},
+		{"price and true", "and needs conditions, not a number"},
+		{"true or locale", "expected a condition, not a string"},
+		{"not price", "expected a condition, not a number"},
+		{"locale in de-de", "expected [ after in"},
+		{"locale in [de-de at-de]", "expected , or ] in list"},
+		{"locale in [de-de,", "unexpected end of expression"},
+		{"locale in [", "unexpected end of expression"},
+		{"locale in [(]", `expected a list item, got "("`},
+		{"price in [cheap]", "cheap is not a number"},
+		{"price in ['1200']", "1200 is not a number"},
+		{"true in [true]", "in needs a number or string"},
+		{"(price < 1", "expected )"},
+		{"price < 1)", "unexpected )"},
+		{"price <", "unexpected end of expression"},
+		{"event == 'stock_in", "unterminated string"},
+		{"price < 1 ; true", `unexpected character ';'`},
+		{"true true", "unexpected true"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseRuleExpr(tt.expr)
+		if err == nil {
+			t.Errorf("parseRuleExpr(%q) succeeded, want error %q", tt.expr, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseRuleExpr(%q) error = %q, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func testChannels(names ...string) []*notifierChannel {
+	channels := make([]*notifierChannel, len(names))
+	for i, name := range names {
+		channels[i] = &notifierChannel{stats: NotifierStats{Name: name}}
+	}
+	return channels
+}
+
+func TestCompileRulesErrors(t *testing.T) {
+	tests := []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"when": "price <"}, "rule r (line 7): when: unexpected end of expression"},
+		{map[string]string{"channels": "ntfy,email"}, "rule r (line 7): channel email is not in NOTIFY_CHANNELS"},
+		{map[string]string{"priority": "0"}, "rule r (line 7): priority must be 1 to 5"},
+		{map[string]string{"priority": "6"}, "rule r (line 7): priority must be 1 to 5"},
+		{map[string]string{"priority": "high"}, "rule r (line 7): priority must be 1 to 5"},
+		{map[string]string{"drop": "yes"}, "rule r (line 7): drop must be true or false"},
+		{map[string]string{"title": "{{.product"}, "rule r (line 7): template: title:1: unclosed action"},
+		{map[string]string{"template": "{{end}}"}, "rule r (line 7): template: template:1: unexpected {{end}}"},
+	}
+
+	for _, tt := range tests {
+		_, err := compileRules([]RuleSpec{{Name: "r", Line: 7, Values: tt.values}}, testChannels("ntfy", "discord"))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("compileRules(%v) error = %v, want %q", tt.values, err, tt.want)
+		}
+	}
+}
+
+func TestRulesApply(t *testing.T) {
+	file, err := parseConfig("config.toml", `
+[rule.night]
+when = "event != 'stock_in' and (time >= 23:00 or time < 08:00)"
+drop = true
+
+[rule.cheap]
+when = "price <= 2400 and locale in [de-de, at-de]"
+channels = ["Telegram"]
+priority = 4
+title = "{{.product}} for {{.price_text}}"
+template = "{{.body}}\n\nBelow your limit in {{.locale}}!"
+
+[rule.fallback]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := testChannels("ntfy", "telegram")
+	rules, err := compileRules(file.rules, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := testRuleVars("12:00", 2329)
+	rule := matchRule(rules, vars)
+	if rule == nil || rule.Name != "cheap" {
+		t.Fatalf("matched %v, want cheap", rule)
+	}
+	n, err := rule.Apply(Notification{Title: "RTX 5090 In Stock", Body: "Go!", Priority: 5}, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != "RTX 5090 for 2329.00 EUR" || n.Body != "Go!\n\nBelow your limit in de-de!" || n.Priority != 4 {
+		t.Errorf("applied = %q, %q, priority %d", n.Title, n.Body, n.Priority)
+	}
+	if selected := ruleChannels(rule, channels); len(selected) != 1 || selected[0] != channels[1] {
+		t.Errorf("channels = %v, want telegram", selected)
+	}
+
+	// First match wins, later rules only see what earlier ones let through
+	if rule := matchRule(rules, testRuleVars("12:00", 2500)); rule == nil || rule.Name != "fallback" {
+		t.Errorf("expensive matched %v, want fallback", rule)
+	}
+	if got := ruleChannels(rules[2], channels); len(got) != 2 {
+		t.Errorf("rule without channels keeps %d channels, want 2", len(got))
+	}
+	night := testRuleVars("02:00", 2329)
+	night["event"] = EventStockOut
+	if rule := matchRule(rules, night); rule == nil || !rule.Drop {
+		t.Errorf("stock_out at night matched %v, want night", rule)
+	}
+}
+
+func TestRuleApplyTemplateError(t *testing.T) {
+	rule, err := compileRule(RuleSpec{Name: "typo", Values: map[string]string{"title": "{{.prodcut}}"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rule.Apply(Notification{Title: "t"}, testRuleVars("12:00", 0)); err == nil {
+		t.Error("missing template variable didn't fail")
+	}
+
+	// An unknown price renders as zero and an empty price_text
+	rule, _ = compileRule(RuleSpec{Name: "price", Values: map[string]string{"title": "{{.price}}|{{.price_text}}"}}, nil)
+	n, err := rule.Apply(Notification{}, testRuleVars("12:00", math.NaN()))
+	if err != nil || n.Title != "0|" {
+		t.Errorf("unknown price title = %q, %v", n.Title, err)
+	}
+}
+
+func TestStoredVars(t *testing.T) {
+	at := time.Date(2026, 10, 17, 22, 30, 0, 0, time.Local) // A Saturday
+	vars := storedVars(StoredEvent{
+		Time:    at,
+		Type:    StoredNotification,
+		Target:  "at-de/5080",
+		Message: "RTX 5080 In Stock",
+		Details: map[string]string{"channel": "ntfy", "event": EventStockIn},
+	})
+	want := map[string]interface{}{
+		"event": EventStockIn, "target": "at-de/5080", "locale": "at-de", "title": "RTX 5080 In Stock",
+		"time": "22:30", "weekday": "sat", "hour": 22.0, "priority": 5.0,
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Errorf("%s = %v, want %v", name, vars[name], value)
+		}
+	}
+	if price := vars["price"].(float64); !math.IsNaN(price) {
+		t.Errorf("price = %v, want unknown", price)
+	}
+
+	vars = storedVars(StoredEvent{
+		Time:    at,
+		Type:    StoredStockIn,
+		Target:  "de-de/5090",
+		SKU:     "PRO5090FE",
+		Details: map[string]string{"price": "2329.00", "currency": "EUR"},
+	})
+	if vars["event"] != StoredStockIn || vars["sku"] != "PRO5090FE" || vars["price"] != 2329.0 || vars["currency"] != "EUR" {
+		t.Errorf("stock_in vars = %v", vars)
+	}
+}
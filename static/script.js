@@ -102,7 +102,7 @@ function connectSSE() {
         updateMetric('currentSku', data.metrics.current_sku || 'N/A');
         updateMetric('errorCount', data.metrics.error_count_24h);
         updateMetric('apiRequests', data.metrics.api_requests_24h);
-        updateMetric('ntfySent', data.metrics.ntfy_messages_sent);
+        updateMetric('ntfySent', data.metrics.notifications_sent_24h);
         updateMetric('startTime', new Date(data.metrics.start_time).toLocaleString());
 
         // Check error rate when error count updates
@@ -9,7 +9,7 @@ class FETracker {
         // Other initialization
         this.eventSource = null;
         this.reconnectAttempts = 0;
-        this.lastSku = null;
+        this.lastEventId = null;
         this.lastPurchaseUrl = '';
     }
 
@@ -35,16 +35,31 @@ class FETracker {
     }
 
     connectSSE() {
-        this.eventSource = new EventSource('/events');
+        // Resume after the last seen event so nothing is missed while reconnecting
+        const url = this.lastEventId ? `/events?last_event_id=${this.lastEventId}` : '/events';
+        this.eventSource = new EventSource(url);
         this.setupSSEHandlers();
     }
 
     setupSSEHandlers() {
-        this.eventSource.onmessage = (event) => {
-            const data = JSON.parse(event.data);
-            this.handleServerUpdate(data);
+        const handlers = {
+            status: (data) => this.handleServerUpdate(data),
+            stock_in: (data) => console.log(`In stock: ${data.target} (${data.sku})`),
+            stock_out: (data) => console.log(`Sold out: ${data.target} (${data.sku}) after ${Math.round(data.duration_seconds)}s`),
+            sku_changed: (data) => this.handleSkuChanged(data),
+            price_changed: (data) => console.log(`Price of ${data.target}: ${data.old_price || '-'} -> ${data.new_price} ${data.currency}`),
+            error_threshold: (data) => console.warn(`Error threshold reached: ${data.errors_last_minute} errors, last: ${data.last_error}`),
+            notification_sent: (data) => console.log(`Notification sent via ${data.channel}: ${data.title}`)
         };
 
+        Object.entries(handlers).forEach(([type, handler]) => {
+            this.eventSource.addEventListener(type, (event) => {
+                if (event.lastEventId) this.lastEventId = event.lastEventId;
+                this.reconnectAttempts = 0;
+                handler(JSON.parse(event.data));
+            });
+        });
+
         this.eventSource.onerror = () => {
             this.handleSSEError();
         };
@@ -53,7 +68,6 @@ class FETracker {
     handleServerUpdate(data) {
         this.metrics.updateMetrics(data);
         this.updatePurchaseButton(data.metrics);
-        this.checkSkuChange(data.metrics.current_sku);
     }
 
     handleSSEError() {
@@ -107,13 +121,8 @@ class FETracker {
         }
     }
 
-    checkSkuChange(currentSku) {
-        if (currentSku && currentSku !== this.lastSku) {
-            if (this.lastSku !== null) {
-                this.notifications.show('SKU Changed', `New SKU detected: ${currentSku}`);
-            }
-            this.lastSku = currentSku;
-        }
+    handleSkuChanged(change) {
+        this.notifications.show('SKU Changed', `${change.target}: ${change.old_sku} -> ${change.new_sku}`);
     }
 }
 
@@ -322,11 +331,67 @@ class MetricsManager {
         this.updateMetric('uptime', data.uptime);
         this.updateMetric('currentSku', data.metrics.current_sku || 'N/A');
         this.updateMetric('errorCount', data.metrics.error_count_24h);
-        this.updateMetric('ntfySent', data.metrics.ntfy_messages_sent);
+        this.updateMetric('ntfySent', data.metrics.notifications_sent_24h);
         this.updateMetric('startTime', new Date(data.metrics.start_time).toLocaleString());
 
         // Check error rate
         this.checkErrorRate(data.metrics.error_count_24h);
+
+        this.updateTargets(data.targets || []);
+    }
+
+    updateTargets(targets) {
+        const list = document.getElementById('targetList');
+        if (!list) return;
+
+        list.replaceChildren(...targets.map(target => {
+            const row = document.createElement('div');
+            row.className = 'metric-row';
+
+            const label = document.createElement('span');
+            label.className = 'metric-label';
+            label.textContent = `${target.product || `RTX ${target.gpu_model}`} (${target.locale}):`;
+
+            const value = target.in_stock ? document.createElement('a') : document.createElement('span');
+            value.className = target.in_stock ? 'metric-value status-ok' : 'metric-value';
+            value.textContent = `${target.current_sku || 'N/A'} - ${target.in_stock ? 'In stock' : 'Out of stock'}`;
+            if (target.in_stock) {
+                value.href = target.purchase_url;
+                value.target = '_blank';
+            }
+
+            const info = target.product_info || {};
+            if (info.price) {
+                value.textContent += ` - ${this.formatPrice(info.price, info.currency)}`;
+                value.title = this.priceHistoryText(target.price_history || []);
+            }
+
+            if (info.image_url) {
+                const image = document.createElement('img');
+                image.className = 'target-image';
+                image.src = info.image_url;
+                image.alt = target.product || '';
+                row.append(image);
+            }
+
+            row.append(label, value);
+            return row;
+        }));
+    }
+
+    formatPrice(price, currency) {
+        try {
+            return new Intl.NumberFormat(undefined, { style: 'currency', currency }).format(price);
+        } catch {
+            return `${price.toFixed(2)} ${currency || ''}`.trim();
+        }
+    }
+
+    // Tooltip with the recent price changes, newest first
+    priceHistoryText(history) {
+        return history.slice(-10).reverse()
+            .map(point => `${new Date(point.time).toLocaleString()}: ${this.formatPrice(point.price, point.currency)}`)
+            .join('\n');
     }
 
     updateMetric(elementId, value) {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StockState is the availability state of a single SKU
+type StockState string
+
+const (
+	StockOutOfStock StockState = "out_of_stock"
+	StockInStock    StockState = "in_stock"
+)
+
+// StockTransition tells the caller which alert, if any, a poll result needs
+type StockTransition int
+
+const (
+	StockUnchanged StockTransition = iota
+	StockCameInStock
+	StockStillInStock // Re-alert interval elapsed while stock stayed up
+	StockSoldOut
+)
+
+type skuStock struct {
+	State        StockState
+	Since        time.Time
+	LastAlert    time.Time
+	Acknowledged bool // Reminders are muted until the next restock
+}
+
+// StockTracker runs the out_of_stock -> in_stock -> out_of_stock state
+// machine for every SKU seen by a monitor
+type StockTracker struct {
+	realertInterval time.Duration
+	skus            map[string]*skuStock
+	mu              sync.Mutex
+}
+
+func newStockTracker(realertInterval time.Duration) *StockTracker {
+	return &StockTracker{
+		realertInterval: realertInterval,
+		skus:            make(map[string]*skuStock),
+	}
+}
+
+// SetRealertInterval changes the reminder interval, used on config reload
+func (st *StockTracker) SetRealertInterval(d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.realertInterval = d
+}
+
+// Observe records one poll result and returns the resulting transition.
+// For StockSoldOut the returned duration is how long stock lasted.
+func (st *StockTracker) Observe(sku string, inStock bool, now time.Time) (StockTransition, time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state, ok := st.skus[sku]
+	if !ok {
+		state = &skuStock{State: StockOutOfStock, Since: now}
+		st.skus[sku] = state
+	}
+
+	switch {
+	case inStock && state.State == StockOutOfStock:
+		state.State = StockInStock
+		state.Since = now
+		state.LastAlert = now
+		state.Acknowledged = false
+		return StockCameInStock, 0
+	case inStock && !state.Acknowledged && st.realertInterval > 0 && now.Sub(state.LastAlert) >= st.realertInterval:
+		state.LastAlert = now
+		return StockStillInStock, now.Sub(state.Since)
+	case !inStock && state.State == StockInStock:
+		lasted := now.Sub(state.Since)
+		state.State = StockOutOfStock
+		state.Since = now
+		return StockSoldOut, lasted
+	}
+	return StockUnchanged, 0
+}
+
+// Retire forgets sku after the FE SKU rotated away from it. Returns
+// StockSoldOut and how long stock lasted if it was still in stock.
+func (st *StockTracker) Retire(sku string, now time.Time) (StockTransition, time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state, ok := st.skus[sku]
+	delete(st.skus, sku)
+	if !ok || state.State != StockInStock {
+		return StockUnchanged, 0
+	}
+	return StockSoldOut, now.Sub(state.Since)
+}
+
+// State returns the current state of sku and when it was entered
+func (st *StockTracker) State(sku string) (StockState, time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state, ok := st.skus[sku]
+	if !ok {
+		return StockOutOfStock, time.Time{}
+	}
+	return state.State, state.Since
+}
+
+// Acknowledge mutes the reminders for sku while it stays in stock. Returns
+// false if sku is not in stock.
+func (st *StockTracker) Acknowledge(sku string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state, ok := st.skus[sku]
+	if !ok || state.State != StockInStock {
+		return false
+	}
+	state.Acknowledged = true
+	return true
+}
+
+// Acknowledged reports whether the alert for sku was acknowledged
+func (st *StockTracker) Acknowledged(sku string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	state, ok := st.skus[sku]
+	return ok && state.Acknowledged
+}
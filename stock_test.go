@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// One poll result at an offset from the start, with the expected outcome
+type stockPoll struct {
+	at      time.Duration
+	inStock bool
+	want    StockTransition
+	lasted  time.Duration
+}
+
+func TestStockTracker(t *testing.T) {
+	tests := []struct {
+		name    string
+		realert time.Duration
+		polls   []stockPoll
+	}{
+		{
+			name:    "out of stock stays quiet",
+			realert: 5 * time.Minute,
+			polls: []stockPoll{
+				{0, false, StockUnchanged, 0},
+				{time.Second, false, StockUnchanged, 0},
+			},
+		},
+		{
+			name:    "restock and sell out",
+			realert: 5 * time.Minute,
+			polls: []stockPoll{
+				{0, false, StockUnchanged, 0},
+				{time.Second, true, StockCameInStock, 0},
+				{2 * time.Second, true, StockUnchanged, 0},
+				{3 * time.Second, true, StockUnchanged, 0},
+				{40 * time.Second, false, StockSoldOut, 39 * time.Second},
+				{41 * time.Second, false, StockUnchanged, 0},
+			},
+		},
+		{
+			name:    "in stock on the first poll",
+			realert: 5 * time.Minute,
+			polls: []stockPoll{
+				{0, true, StockCameInStock, 0},
+				{time.Second, true, StockUnchanged, 0},
+			},
+		},
+		{
+			name:    "reminders at the realert interval",
+			realert: 5 * time.Minute,
+			polls: []stockPoll{
+				{0, true, StockCameInStock, 0},
+				{5*time.Minute - time.Second, true, StockUnchanged, 0},
+				{5 * time.Minute, true, StockStillInStock, 5 * time.Minute},
+				{9 * time.Minute, true, StockUnchanged, 0},
+				{10 * time.Minute, true, StockStillInStock, 10 * time.Minute},
+				{11 * time.Minute, false, StockSoldOut, 11 * time.Minute},
+			},
+		},
+		{
+			name:    "no reminders without interval",
+			realert: 0,
+			polls: []stockPoll{
+				{0, true, StockCameInStock, 0},
+				{time.Hour, true, StockUnchanged, 0},
+				{2 * time.Hour, false, StockSoldOut, 2 * time.Hour},
+			},
+		},
+		{
+			name:    "each restock alerts again",
+			realert: 5 * time.Minute,
+			polls: []stockPoll{
+				{0, true, StockCameInStock, 0},
+				{time.Second, false, StockSoldOut, time.Second},
+				{2 * time.Second, true, StockCameInStock, 0},
+				{3 * time.Second, false, StockSoldOut, time.Second},
+			},
+		},
+	}
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStockTracker(tt.realert)
+			for _, poll := range tt.polls {
+				got, lasted := st.Observe("PRO5090FE", poll.inStock, start.Add(poll.at))
+				if got != poll.want || lasted != poll.lasted {
+					t.Errorf("poll at %v in stock %v = %v, %v; want %v, %v",
+						poll.at, poll.inStock, got, lasted, poll.want, poll.lasted)
+				}
+			}
+		})
+	}
+}
+
+func TestStockTrackerState(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	st := newStockTracker(time.Minute)
+
+	if state, since := st.State("A"); state != StockOutOfStock || !since.IsZero() {
+		t.Errorf("unknown SKU state = %v, %v", state, since)
+	}
+	st.Observe("A", true, start)
+	st.Observe("B", false, start)
+	if state, since := st.State("A"); state != StockInStock || !since.Equal(start) {
+		t.Errorf("A state = %v, %v; want in stock since start", state, since)
+	}
+	if state, _ := st.State("B"); state != StockOutOfStock {
+		t.Errorf("B state = %v, SKUs must not share state", state)
+	}
+}
+
+func TestStockTrackerAcknowledge(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	st := newStockTracker(time.Minute)
+
+	if st.Acknowledge("A") {
+		t.Error("acknowledged a SKU that is not in stock")
+	}
+	st.Observe("A", true, start)
+	if !st.Acknowledge("A") || !st.Acknowledged("A") {
+		t.Fatal("acknowledge of an in stock SKU failed")
+	}
+
+	// Acknowledged stock gets no reminders, but the sell out and the next
+	// restock still alert and clear the acknowledgement
+	steps := []stockPoll{
+		{2 * time.Minute, true, StockUnchanged, 0},
+		{3 * time.Minute, false, StockSoldOut, 3 * time.Minute},
+		{4 * time.Minute, true, StockCameInStock, 0},
+		{5 * time.Minute, true, StockStillInStock, time.Minute},
+	}
+	for _, step := range steps {
+		got, lasted := st.Observe("A", step.inStock, start.Add(step.at))
+		if got != step.want || lasted != step.lasted {
+			t.Errorf("poll at %v = %v, %v; want %v, %v", step.at, got, lasted, step.want, step.lasted)
+		}
+	}
+	if st.Acknowledged("A") {
+		t.Error("acknowledgement survived a restock")
+	}
+}
+
+func TestStockTrackerRetire(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	st := newStockTracker(time.Minute)
+	st.Observe("IN", true, start)
+	st.Observe("OUT", false, start)
+
+	if got, lasted := st.Retire("IN", start.Add(time.Minute)); got != StockSoldOut || lasted != time.Minute {
+		t.Errorf("Retire(in stock) = %v, %v; want sold out after 1m", got, lasted)
+	}
+	if got, _ := st.Retire("OUT", start); got != StockUnchanged {
+		t.Errorf("Retire(out of stock) = %v", got)
+	}
+	if got, _ := st.Retire("UNKNOWN", start); got != StockUnchanged {
+		t.Errorf("Retire(unknown) = %v", got)
+	}
+	if state, _ := st.State("IN"); state != StockOutOfStock {
+		t.Errorf("retired SKU state = %v", state)
+	}
+	// A SKU that comes back later starts over
+	if got, _ := st.Observe("IN", true, start.Add(time.Hour)); got != StockCameInStock {
+		t.Errorf("retired SKU back in stock = %v", got)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event types kept in the event store
+const (
+	StoredStockIn      = "stock_in"
+	StoredStockOut     = "stock_out"
+	StoredSKUChanged   = "sku_changed"
+	StoredPriceChanged = "price_changed"
+	StoredError        = "error"
+	StoredNotification = "notification"
+	StoredAPIRequests  = "api_requests" // Per-minute rollup, Count holds the number of requests
+)
+
+// StoredEvent is one entry of the event history
+type StoredEvent struct {
+	ID      uint64            `json:"id"`
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	Target  string            `json:"target,omitempty"`
+	SKU     string            `json:"sku,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Count   int               `json:"count,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// EventStore is an append-only JSON Lines file under the data dir with an
+// in-memory copy of every event inside the retention window
+type EventStore struct {
+	path      string
+	file      *os.File
+	events    []StoredEvent // Ordered by time
+	nextID    uint64
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+// Default number of days events are kept
+const DEFAULT_EVENT_RETENTION_DAYS = 30
+
+var store *EventStore
+
+// Open the store in dir, dropping events older than retention
+func openEventStore(dir string, retention time.Duration) (*EventStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data dir: %v", err)
+	}
+
+	s := &EventStore{
+		path:      filepath.Join(dir, "events.jsonl"),
+		retention: retention,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *EventStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading event store: %v", err)
+	}
+	defer f.Close()
+
+	cutoff := time.Now().Add(-s.retention)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var event StoredEvent
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil {
+				log.Printf("Skipping corrupt event store entry: %v", jsonErr)
+			} else {
+				if event.ID > s.nextID {
+					s.nextID = event.ID
+				}
+				if event.Time.After(cutoff) {
+					s.events = append(s.events, event)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading event store: %v", err)
+		}
+	}
+
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].Time.Before(s.events[j].Time)
+	})
+	return nil
+}
+
+// Rewrite the file with the retained events and reopen it for appending
+func (s *EventStore) compact() error {
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compacting event store: %v", err)
+	}
+	writer := bufio.NewWriter(f)
+	enc := json.NewEncoder(writer)
+	for _, event := range s.events {
+		if err := enc.Encode(event); err != nil {
+			f.Close()
+			return fmt.Errorf("compacting event store: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("compacting event store: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("compacting event store: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("compacting event store: %v", err)
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening event store: %v", err)
+	}
+	return nil
+}
+
+// Record appends event, filling in its ID and time. Events with an earlier
+// caller supplied time are inserted in order, Since relies on it.
+func (s *EventStore) Record(event StoredEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	event.ID = s.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	i := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Time.After(event.Time)
+	})
+	s.events = append(s.events, StoredEvent{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = event
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode event: %v", err)
+		return
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write event store: %v", err)
+	}
+}
+
+// Since returns a copy of the events of the given type newer than t, all
+// types if eventType is empty
+func (s *EventStore) Since(eventType string, t time.Time) []StoredEvent {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Time.After(t)
+	})
+	var events []StoredEvent
+	for _, event := range s.events[start:] {
+		if eventType == "" || event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Prune drops events older than the retention window
+func (s *EventStore) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.retention)
+	start := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Time.After(cutoff)
+	})
+	if start == 0 {
+		return nil
+	}
+	s.events = append([]StoredEvent(nil), s.events[start:]...)
+	return s.compact()
+}
+
+func (s *EventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Load the store from DATA_DIR with EVENT_RETENTION_DAYS retention
+func loadEventStore() (*EventStore, error) {
+	days := envInt("EVENT_RETENTION_DAYS", DEFAULT_EVENT_RETENTION_DAYS)
+	log.Printf("- EVENT_RETENTION_DAYS: %d", days)
+	return openEventStore(dataDir(), time.Duration(days)*24*time.Hour)
+}
+
+// Restore the 24h counters from the stored history so they survive restarts
+func seedMetricsFromStore(s *EventStore) {
+	dayAgo := time.Now().Add(-24 * time.Hour)
+
+	errorTracker.mu.Lock()
+	for _, event := range s.Since(StoredError, dayAgo) {
+		errorTracker.record(Error{Timestamp: event.Time, Err: fmt.Errorf("%s", event.Message)})
+	}
+	errorTracker.mu.Unlock()
+
+	for _, event := range s.Since(StoredAPIRequests, dayAgo) {
+		metrics.apiRequests.Add(event.Time, event.Count)
+	}
+	for _, event := range s.Since(StoredNotification, dayAgo) {
+		metrics.notifications.Add(event.Time, 1)
+	}
+}
+
+// Write per-minute request rollups and prune old events until ctx is done
+func runStoreMaintenance(done <-chan struct{}) {
+	rollupTicker := time.NewTicker(time.Minute)
+	pruneTicker := time.NewTicker(time.Hour)
+	defer rollupTicker.Stop()
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-done:
+			recordAPIRequestRollup()
+			return
+		case <-rollupTicker.C:
+			recordAPIRequestRollup()
+		case <-pruneTicker.C:
+			if err := store.Prune(); err != nil {
+				log.Printf("Failed to prune event store: %v", err)
+			}
+		}
+	}
+}
+
+func recordAPIRequestRollup() {
+	if count := metrics.takeRequestRollup(); count > 0 {
+		store.Record(StoredEvent{Type: StoredAPIRequests, Count: count})
+	}
+}
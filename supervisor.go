@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Health states of a monitor and of the whole tracker
+const (
+	HealthRunning  = "running"
+	HealthDegraded = "degraded"
+	HealthStopped  = "stopped"
+)
+
+// Restart backoff after a crash, doubling per consecutive crash
+const (
+	restartBaseBackoff = time.Second
+	restartMaxBackoff  = 5 * time.Minute
+)
+
+// Returned by checks with nothing to do, doesn't count as a result
+var errCheckSkipped = errors.New("check skipped")
+
+// monitorHealth tracks the check results of a monitor, guarded by its mutex
+type monitorHealth struct {
+	failures    int // Consecutive failed checks
+	lastError   string
+	crashes     int       // Consecutive panics
+	pausedUntil time.Time // No checks run before this after a crash
+}
+
+func (h monitorHealth) state(now time.Time) string {
+	if h.failures > 0 || now.Before(h.pausedUntil) {
+		return HealthDegraded
+	}
+	return HealthRunning
+}
+
+// Restart delay after the given number of consecutive crashes
+func restartBackoff(crashes int) time.Duration {
+	backoff := restartBaseBackoff
+	for i := 1; i < crashes && backoff < restartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > restartMaxBackoff {
+		backoff = restartMaxBackoff
+	}
+	return backoff
+}
+
+// Run one check of m, recovering from panics and recording the result.
+// While the monitor waits for its restart after a crash or the upstream
+// asked to retry later, checks are skipped.
+func (m *Monitor) runCheck(ctx context.Context, check func(*Monitor, context.Context) error) {
+	if upstreamBackoff.Paused() || m.paused() {
+		return
+	}
+	m.recordCheck(safeCheck(ctx, m, check))
+}
+
+// Whether checks wait for the restart after a crash
+func (m *Monitor) paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Now().Before(m.health.pausedUntil)
+}
+
+// Update the health of m with the result of a check
+func (m *Monitor) recordCheck(crashed bool, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errCheckSkipped) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case err == nil:
+		if m.health.failures > 0 {
+			log.Printf("[%s] Recovered after %d failed check(s)", m.Target.ID, m.health.failures)
+		}
+		m.health = monitorHealth{}
+	case crashed:
+		m.health.failures++
+		m.health.crashes++
+		m.health.lastError = err.Error()
+		backoff := restartBackoff(m.health.crashes)
+		m.health.pausedUntil = time.Now().Add(backoff)
+		log.Printf("[%s] Monitor crashed, restarting in %v: %v", m.Target.ID, backoff, err)
+	default:
+		m.health.failures++
+		m.health.lastError = err.Error()
+		log.Printf("[%s] Check failed (%d in a row): %v", m.Target.ID, m.health.failures, err)
+	}
+}
+
+// Call check and turn a panic into an error
+func safeCheck(ctx context.Context, m *Monitor, check func(*Monitor, context.Context) error) (crashed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] Panic in check: %v\n%s", m.Target.ID, r, debug.Stack())
+			crashed, err = true, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return false, check(m, ctx)
+}
+
+// Supervise runs the scheduler until ctx is cancelled, restarting it with
+// backoff if its loop panics
+func (s *Scheduler) Supervise(ctx context.Context) error {
+	crashes := 0
+	for {
+		err := s.runSafe(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		crashes++
+		backoff := restartBackoff(crashes)
+		log.Printf("Scheduler stopped, restarting in %v: %v", backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) runSafe(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in scheduler: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.Run(ctx)
+}
+
+// Health is stopped while the scheduler loop isn't running, degraded if any
+// monitor is and running otherwise
+func (s *Scheduler) Health() string {
+	if s == nil || atomic.LoadInt32(&s.running) == 0 {
+		return HealthStopped
+	}
+	now := time.Now()
+	monitors, _ := s.snapshot()
+	for _, m := range monitors {
+		m.mu.Lock()
+		state := m.health.state(now)
+		m.mu.Unlock()
+		if state != HealthRunning {
+			return HealthDegraded
+		}
+	}
+	return HealthRunning
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Target is a single product page (GPU model + locale) being tracked
+type Target struct {
+	ID          string         `json:"id"`
+	Locale      string         `json:"locale"`
+	GpuModel    string         `json:"gpu_model"` // e.g. 5070 Ti
+	Product     string         `json:"product"`   // e.g. RTX 5070 Ti
+	ProductURL  string         `json:"product_url"`
+	PinnedSKU   string         `json:"pinned_sku,omitempty"`
+	NamePattern string         `json:"name_pattern,omitempty"`
+	ApiURL      string         `json:"-"`
+	namePattern *regexp.Regexp // Compiled NamePattern
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2}-[a-z]{2}$`)
+
+// Parse a marketplace product URL into a target. The model comes from the
+// last path segment, e.g. nvidia-geforce-rtx-5070-ti. Options can follow
+// in the fragment: #sku=PINNED_SKU&name=REGEX (URL encoded).
+func parseTarget(productURL string) (Target, error) {
+	rawURL, fragment, _ := strings.Cut(productURL, "#")
+	u, err := url.Parse(strings.ToLower(rawURL))
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid URL %q: %v", productURL, err)
+	}
+
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+	if len(segments) < 2 || !localePattern.MatchString(segments[0]) {
+		return Target{}, fmt.Errorf("invalid URL format %q. Expected pattern: .../xx-xx/.../product-name", productURL)
+	}
+	locale := segments[0]
+
+	// nvidia-geforce-rtx-5070-ti: RTX family, model 5070 Ti
+	slug := strings.TrimPrefix(segments[len(segments)-1], "nvidia-")
+	slug = strings.TrimPrefix(slug, "geforce-")
+	family := ""
+	if strings.HasPrefix(slug, "rtx-") {
+		family, slug = "RTX", strings.TrimPrefix(slug, "rtx-")
+	}
+	words := strings.Split(slug, "-")
+	for i, word := range words {
+		words[i] = modelWord(word)
+	}
+	gpuModel := strings.TrimSpace(strings.Join(words, " "))
+	if len(nameWords(gpuModel)) == 0 {
+		return Target{}, fmt.Errorf("invalid URL %q: no product name", productURL)
+	}
+	product := strings.TrimSpace(family + " " + gpuModel)
+
+	target := Target{
+		ID:         locale + "/" + slug,
+		Locale:     locale,
+		GpuModel:   gpuModel,
+		Product:    product,
+		ProductURL: rawURL,
+		ApiURL: fmt.Sprintf("https://api.nvidia.partners/edge/product/search?page=1&limit=12&locale=%s&gpu=%s",
+			locale, url.PathEscape(product)),
+	}
+
+	options, err := url.ParseQuery(fragment)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid options in %q: %v", productURL, err)
+	}
+	for key := range options {
+		switch key {
+		case "sku":
+			target.PinnedSKU = options.Get(key)
+		case "name":
+			target.NamePattern = options.Get(key)
+			if target.namePattern, err = regexp.Compile(target.NamePattern); err != nil {
+				return Target{}, fmt.Errorf("invalid name pattern in %q: %v", productURL, err)
+			}
+		default:
+			return Target{}, fmt.Errorf("unknown option %q in %q, use sku or name", key, productURL)
+		}
+	}
+	return target, nil
+}
+
+// Parse a comma, space or newline separated list of product URLs
+func parseTargets(raw string) ([]Target, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+
+	targets := make([]Target, 0, len(fields))
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		target, err := parseTarget(field)
+		if err != nil {
+			return nil, err
+		}
+		if seen[target.ID] {
+			log.Printf("Skipping duplicate target %s", target.ID)
+			continue
+		}
+		seen[target.ID] = true
+		targets = append(targets, target)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no product URLs configured")
+	}
+	return targets, nil
+}
+
+// Monitor holds the live state of a single target
+type Monitor struct {
+	Target      Target
+	mu          sync.Mutex
+	currentSKU  string
+	purchaseURL string
+	lastCheck   time.Time
+	lastRecheck time.Time // When the last out of schedule check was queued
+	stock       *StockTracker
+	skuChanges  []SKUChange
+	health      monitorHealth
+	product     ProductInfo
+	prices      []PricePoint // Price changes, oldest first
+}
+
+// SKUChange records a rotation of the FE SKU of a target
+type SKUChange struct {
+	Target string    `json:"target"`
+	OldSKU string    `json:"old_sku"`
+	NewSKU string    `json:"new_sku"`
+	Time   time.Time `json:"time"`
+}
+
+// Limit SKU change history per target
+const maxSKUChanges = 20
+
+// TargetStatus is the per-target view exposed in /status and /events
+type TargetStatus struct {
+	ID                  string       `json:"id"`
+	Locale              string       `json:"locale"`
+	GpuModel            string       `json:"gpu_model"`
+	Product             string       `json:"product"`
+	ProductURL          string       `json:"product_url"`
+	PinnedSKU           string       `json:"pinned_sku,omitempty"`
+	CurrentSKU          string       `json:"current_sku"`
+	PurchaseURL         string       `json:"purchase_url"`
+	InStock             bool         `json:"in_stock"`
+	StockState          StockState   `json:"stock_state"`
+	StateSince          *time.Time   `json:"state_since,omitempty"`
+	Acknowledged        bool         `json:"acknowledged"`
+	Health              string       `json:"health"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastCheck           time.Time    `json:"last_check"`
+	SKUChanges          []SKUChange  `json:"sku_changes"`
+	ProductInfo         ProductInfo  `json:"product_info"`
+	PriceHistory        []PricePoint `json:"price_history"`
+}
+
+// A pinned SKU is polled right away, without waiting for discovery
+func newMonitor(target Target, realertInterval time.Duration) *Monitor {
+	m := &Monitor{
+		Target:     target,
+		currentSKU: target.PinnedSKU,
+		stock:      newStockTracker(realertInterval),
+	}
+	m.loadPriceHistory()
+	return m
+}
+
+// Update the current SKU and record a change if it differs from the
+// previously discovered one. Returns the recorded change, if any.
+func (m *Monitor) updateSKU(sku string) *SKUChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.currentSKU
+	m.currentSKU = sku
+	if old == "" || old == sku {
+		return nil
+	}
+
+	change := SKUChange{
+		Target: m.Target.ID,
+		OldSKU: old,
+		NewSKU: sku,
+		Time:   time.Now(),
+	}
+	m.skuChanges = append(m.skuChanges, change)
+	if len(m.skuChanges) > maxSKUChanges {
+		m.skuChanges = m.skuChanges[len(m.skuChanges)-maxSKUChanges:]
+	}
+	return &change
+}
+
+func (m *Monitor) updatePurchaseURL(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purchaseURL = url
+}
+
+func (m *Monitor) updateLastCheck() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastCheck = time.Now()
+}
+
+// Claim an out of schedule check, refused while a check ran or was queued
+// within interval, so rechecks never poll faster than the schedule
+func (m *Monitor) claimRecheck(interval time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastCheck) < interval || now.Sub(m.lastRecheck) < interval {
+		return false
+	}
+	m.lastRecheck = now
+	return true
+}
+
+// Acknowledge the stock alert of the current SKU
+func (m *Monitor) acknowledge() error {
+	m.mu.Lock()
+	sku := m.currentSKU
+	m.mu.Unlock()
+
+	if sku == "" || !m.stock.Acknowledge(sku) {
+		return fmt.Errorf("%s is not in stock", m.Target.ID)
+	}
+	log.Printf("[%s] Stock alert for SKU %s acknowledged", m.Target.ID, sku)
+	return nil
+}
+
+func (m *Monitor) status() TargetStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := TargetStatus{
+		ID:          m.Target.ID,
+		Locale:      m.Target.Locale,
+		GpuModel:    m.Target.GpuModel,
+		Product:     m.Target.Product,
+		ProductURL:  m.Target.ProductURL,
+		PinnedSKU:   m.Target.PinnedSKU,
+		CurrentSKU:  m.currentSKU,
+		PurchaseURL: m.purchaseURL,
+		StockState:  StockOutOfStock,
+		LastCheck:   m.lastCheck,
+		SKUChanges:  append([]SKUChange{}, m.skuChanges...),
+		Health:      m.health.state(time.Now()),
+		LastError:   m.health.lastError,
+		ProductInfo: m.product,
+	}
+	status.PriceHistory = append([]PricePoint{}, m.prices...)
+	status.ConsecutiveFailures = m.health.failures
+	if m.currentSKU != "" {
+		state, since := m.stock.State(m.currentSKU)
+		status.StockState = state
+		if !since.IsZero() {
+			status.StateSince = &since
+		}
+		status.Acknowledged = m.stock.Acknowledged(m.currentSKU)
+	}
+	status.InStock = status.StockState == StockInStock
+	return status
+}
+
+// Scheduler drives the checks of every monitor from one pair of tickers:
+// the SKU ticker runs catalog discovery, the stock ticker polls inventory
+// for the cached SKU
+type Scheduler struct {
+	monitors  []*Monitor
+	intervals Intervals
+	added     []*Monitor    // Monitors added by a reload, waiting for their first discovery
+	reload    chan struct{} // Signals Run that targets or intervals changed
+	recheck   chan *Monitor // Stock checks requested out of schedule
+	running   int32         // Set while Run is looping, read atomically
+	mu        sync.Mutex
+}
+
+// Global scheduler so the HTTP handlers can read per-target state
+var scheduler *Scheduler
+
+// Pending re-checks before further requests are refused
+const maxPendingRechecks = 32
+
+func newScheduler(targets []Target, intervals Intervals) *Scheduler {
+	s := &Scheduler{
+		intervals: intervals,
+		reload:    make(chan struct{}, 1),
+		recheck:   make(chan *Monitor, maxPendingRechecks),
+	}
+	for _, target := range targets {
+		s.monitors = append(s.monitors, newMonitor(target, intervals.Realert))
+	}
+	return s
+}
+
+// Current monitors and intervals
+func (s *Scheduler) snapshot() ([]*Monitor, Intervals) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.monitors, s.intervals
+}
+
+// Apply switches to a new target list and intervals without a restart.
+// Monitors of targets that stay keep their state, new targets discover
+// their SKU right away.
+func (s *Scheduler) Apply(targets []Target, intervals Intervals) {
+	s.mu.Lock()
+	existing := make(map[string]*Monitor, len(s.monitors))
+	for _, m := range s.monitors {
+		existing[m.Target.ID] = m
+	}
+
+	monitors := make([]*Monitor, 0, len(targets))
+	for _, target := range targets {
+		m, ok := existing[target.ID]
+		delete(existing, target.ID)
+		if ok && sameTarget(m.Target, target) {
+			monitors = append(monitors, m)
+			continue
+		}
+		if ok {
+			log.Printf("[%s] Target changed, starting over", target.ID)
+		} else {
+			log.Printf("[%s] Target added", target.ID)
+		}
+		m = newMonitor(target, intervals.Realert)
+		monitors = append(monitors, m)
+		s.added = append(s.added, m)
+	}
+	for id := range existing {
+		log.Printf("[%s] Target removed", id)
+	}
+
+	s.monitors = monitors
+	s.intervals = intervals
+	s.mu.Unlock()
+
+	for _, m := range monitors {
+		m.stock.SetRealertInterval(intervals.Realert)
+	}
+	select {
+	case s.reload <- struct{}{}:
+	default: // Run hasn't picked up the previous reload yet
+	}
+}
+
+// Whether a and b are configured the same, so a monitor can be kept
+func sameTarget(a, b Target) bool {
+	return a.ProductURL == b.ProductURL && a.PinnedSKU == b.PinnedSKU && a.NamePattern == b.NamePattern
+}
+
+// Monitors added since the last call that are still configured
+func (s *Scheduler) takeAdded() []*Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added []*Monitor
+	for _, m := range s.added {
+		for _, current := range s.monitors {
+			if m == current {
+				added = append(added, m)
+			}
+		}
+	}
+	s.added = nil
+	return added
+}
+
+// Run checks for every monitor on each tick until the context is
+// cancelled. Failed checks only affect the health of their monitor, and the
+// tick intervals follow the upstream backoff.
+func (s *Scheduler) Run(ctx context.Context) error {
+	// Timers instead of tickers so every round uses the current backoff
+	_, intervals := s.snapshot()
+	stockTimer := time.NewTimer(upstreamBackoff.Interval(intervals.Stock))
+	skuTimer := time.NewTimer(upstreamBackoff.Interval(intervals.Sku))
+	defer stockTimer.Stop()
+	defer skuTimer.Stop()
+
+	atomic.StoreInt32(&s.running, 1)
+	defer atomic.StoreInt32(&s.running, 0)
+
+	// Discover SKUs right away instead of waiting for the first SKU tick
+	s.takeAdded()
+	s.dispatch(ctx, 0, (*Monitor).discoverSKU)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-stockTimer.C:
+			_, intervals = s.snapshot()
+			interval := upstreamBackoff.Interval(intervals.Stock)
+			s.dispatchStock(ctx, interval)
+			stockTimer.Reset(interval)
+		case <-skuTimer.C:
+			_, intervals = s.snapshot()
+			interval := upstreamBackoff.Interval(intervals.Sku)
+			s.dispatch(ctx, interval, (*Monitor).discoverSKU)
+			skuTimer.Reset(interval)
+		case m := <-s.recheck:
+			go m.runCheck(ctx, (*Monitor).checkStock)
+		case <-s.reload:
+			for _, m := range s.takeAdded() {
+				go m.runCheck(ctx, (*Monitor).discoverSKU)
+			}
+			_, current := s.snapshot()
+			if current.Stock != intervals.Stock {
+				resetTimer(stockTimer, upstreamBackoff.Interval(current.Stock))
+			}
+			if current.Sku != intervals.Sku {
+				resetTimer(skuTimer, upstreamBackoff.Interval(current.Sku))
+			}
+			intervals = current
+		}
+	}
+}
+
+// Restart a timer that may have fired without being read
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// Run check for every monitor, spreading the calls evenly over the interval
+// so targets don't hit the upstream API in one burst
+func (s *Scheduler) dispatch(ctx context.Context, interval time.Duration, check func(*Monitor, context.Context) error) {
+	monitors, _ := s.snapshot()
+	spread(ctx, interval, len(monitors), func(i int) {
+		monitors[i].runCheck(ctx, check)
+	})
+}
+
+// Poll the stock of every monitor with one inventory request per locale,
+// spread over the interval
+func (s *Scheduler) dispatchStock(ctx context.Context, interval time.Duration) {
+	monitors, _ := s.snapshot()
+
+	var locales []string
+	byLocale := make(map[string][]*Monitor)
+	for _, m := range monitors {
+		locale := m.Target.Locale
+		if _, ok := byLocale[locale]; !ok {
+			locales = append(locales, locale)
+		}
+		byLocale[locale] = append(byLocale[locale], m)
+	}
+
+	spread(ctx, interval, len(locales), func(i int) {
+		checkLocaleStock(ctx, locales[i], byLocale[locales[i]])
+	})
+}
+
+// Call fn for 0..n-1 in goroutines, the i-th after i/n of the interval
+func spread(ctx context.Context, interval time.Duration, n int, fn func(i int)) {
+	for i := 0; i < n; i++ {
+		delay := interval * time.Duration(i) / time.Duration(n)
+		go func(i int) {
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			}
+			fn(i)
+		}(i)
+	}
+}
+
+// Request the inventory of every SKU of a locale at once and apply each
+// listing to its monitor. A failed request counts against every monitor
+// and is recorded under each target ID, a panic crashes every monitor.
+func checkLocaleStock(ctx context.Context, locale string, monitors []*Monitor) {
+	if upstreamBackoff.Paused() {
+		return
+	}
+
+	skus := make(map[*Monitor]string, len(monitors))
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[%s] Panic in stock check: %v\n%s", locale, r, debug.Stack())
+			for m := range skus {
+				m.recordCheck(true, fmt.Errorf("panic: %v", r))
+			}
+		}
+	}()
+
+	var query []string
+	seen := make(map[string]bool)
+	for _, m := range monitors {
+		m.mu.Lock()
+		sku := m.currentSKU
+		m.mu.Unlock()
+		if sku == "" || m.paused() {
+			continue // No SKU discovered yet, or waiting for a restart
+		}
+		skus[m] = sku
+		if !seen[sku] {
+			seen[sku] = true
+			query = append(query, sku)
+		}
+	}
+	if len(query) == 0 {
+		return
+	}
+
+	items, err := fetchInventory(ctx, locale, query, monitors[0].Target)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		for _, m := range monitors {
+			if _, ok := skus[m]; ok {
+				errorTracker.AddError(m.Target.ID, err)
+			}
+		}
+	}
+
+	for _, m := range monitors {
+		sku, ok := skus[m]
+		if !ok {
+			continue
+		}
+		// Recorded even if the response paused the upstream, so a block
+		// shows up in the health of every monitor of the locale
+		m.recordCheck(safeCheck(ctx, m, func(m *Monitor, ctx context.Context) error {
+			if err != nil {
+				return fmt.Errorf("inventory check failed: %w", err)
+			}
+			return m.applyInventory(sku, items)
+		}))
+	}
+}
+
+// Find the monitor of a target by ID
+func (s *Scheduler) monitor(id string) *Monitor {
+	if s == nil {
+		return nil
+	}
+	monitors, _ := s.snapshot()
+	for _, m := range monitors {
+		if m.Target.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Recheck queues an immediate stock check of the target, or of every
+// target if id is empty. Targets checked within the current stock interval
+// are skipped, for a single target that is an error.
+func (s *Scheduler) Recheck(id string) error {
+	if s == nil {
+		return fmt.Errorf("monitoring not started")
+	}
+	if upstreamBackoff.Paused() {
+		return fmt.Errorf("upstream requests are paused")
+	}
+	monitors, intervals := s.snapshot()
+	if id != "" {
+		m := s.monitor(id)
+		if m == nil {
+			return fmt.Errorf("unknown target %q", id)
+		}
+		monitors = []*Monitor{m}
+	}
+
+	interval := upstreamBackoff.Interval(intervals.Stock)
+	for _, m := range monitors {
+		if !m.claimRecheck(interval) {
+			if id != "" {
+				return fmt.Errorf("checked less than %v ago", interval)
+			}
+			continue
+		}
+		select {
+		case s.recheck <- m:
+		default:
+			return fmt.Errorf("re-check already pending")
+		}
+	}
+	return nil
+}
+
+// Acknowledge mutes the stock reminders of a target until its next restock
+func (s *Scheduler) Acknowledge(id string) error {
+	m := s.monitor(id)
+	if m == nil {
+		return fmt.Errorf("unknown target %q", id)
+	}
+	return m.acknowledge()
+}
+
+func (s *Scheduler) targetStatuses() []TargetStatus {
+	if s == nil {
+		return []TargetStatus{}
+	}
+	monitors, _ := s.snapshot()
+	statuses := make([]TargetStatus, 0, len(monitors))
+	for _, m := range monitors {
+		statuses = append(statuses, m.status())
+	}
+	return statuses
+}
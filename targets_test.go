@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	const base = "https://marketplace.nvidia.com/de-de/consumer/graphics-cards/"
+	tests := []struct {
+		url     string
+		id      string
+		model   string
+		product string
+	}{
+		{base + "nvidia-geforce-rtx-5090/", "de-de/5090", "5090", "RTX 5090"},
+		{base + "nvidia-geforce-rtx-5070-ti/", "de-de/5070-ti", "5070 Ti", "RTX 5070 Ti"},
+		{base + "nvidia-geforce-rtx-4080-super/", "de-de/4080-super", "4080 Super", "RTX 4080 Super"},
+		{base + "nvidia-geforce-rtx-4070-ti-super/", "de-de/4070-ti-super", "4070 Ti Super", "RTX 4070 Ti Super"},
+		{base + "NVIDIA-GeForce-RTX-5090-D", "de-de/5090-d", "5090 D", "RTX 5090 D"},
+		{base + "nvidia-titan-rtx/", "de-de/titan-rtx", "Titan RTX", "Titan RTX"},
+	}
+
+	for _, tt := range tests {
+		target, err := parseTarget(tt.url)
+		if err != nil {
+			t.Errorf("parseTarget(%q): %v", tt.url, err)
+			continue
+		}
+		if target.ID != tt.id || target.GpuModel != tt.model || target.Product != tt.product {
+			t.Errorf("parseTarget(%q) = %q, %q, %q; want %q, %q, %q",
+				tt.url, target.ID, target.GpuModel, target.Product, tt.id, tt.model, tt.product)
+		}
+	}
+}
+
+func TestParseTargetOptions(t *testing.T) {
+	target, err := parseTarget("https://marketplace.nvidia.com/fr-fr/consumer/graphics-cards/nvidia-titan-rtx/#sku=TITANRTX&name=%5ETITAN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.PinnedSKU != "TITANRTX" || target.NamePattern != "^TITAN" {
+		t.Errorf("options = %q, %q", target.PinnedSKU, target.NamePattern)
+	}
+	if !target.Matches("Some other card", "TITANRTX", false) || target.Matches("NVIDIA TITAN RTX", "OTHER", true) {
+		t.Error("pinned SKU doesn't decide the match")
+	}
+}
+
+func TestParseTargetErrors(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://marketplace.nvidia.com/nvidia-geforce-rtx-5090", "invalid URL format"},
+		{"https://marketplace.nvidia.com/deu/nvidia-geforce-rtx-5090", "invalid URL format"},
+		{"https://marketplace.nvidia.com/de-de/nvidia-geforce-", "no product name"},
+		{"https://marketplace.nvidia.com/de-de/nvidia-geforce-rtx-", "no product name"},
+		{"https://marketplace.nvidia.com/de-de/nvidia-geforce-rtx-5090#color=red", "unknown option"},
+		{"https://marketplace.nvidia.com/de-de/nvidia-geforce-rtx-5090#name=(", "invalid name pattern"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseTarget(tt.url)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseTarget(%q) error = %v, want %q", tt.url, err, tt.want)
+		}
+	}
+}
+
+// Answer upstream requests with handler instead of the network
+type stubTransport func(*http.Request) *http.Response
+
+func (f stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r), nil
+}
+
+func stubUpstream(t *testing.T, handler func(*http.Request) interface{}) {
+	saved := client
+	client = &http.Client{Transport: stubTransport(func(r *http.Request) *http.Response {
+		body, _ := json.Marshal(handler(r))
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(body))), Request: r}
+	})}
+	t.Cleanup(func() { client = saved })
+}
+
+func TestCheckLocaleStockMapping(t *testing.T) {
+	const base = "https://marketplace.nvidia.com/%s/consumer/graphics-cards/nvidia-geforce-rtx-%s/"
+	tests := []struct {
+		name    string
+		skus    map[string]string // SKU per target ID, targets without one aren't polled
+		items   map[string][]InventoryItem
+		queries map[string]string // SKUs requested per locale
+		want    map[string]bool   // In stock per target ID
+	}{
+		{
+			name: "models of one locale share a response",
+			skus: map[string]string{"de-de/5090": "PRO5090FE", "de-de/5080": "PRO5080FE", "de-de/5070-ti": "PRO5070TIFE"},
+			items: map[string][]InventoryItem{"de-de": {
+				{FeSKU: "PRO5080FE", IsActive: "true", ProductURL: "https://example.com/5080"},
+				{FeSKU: "PRO5090FE", IsActive: "false"},
+			}},
+			queries: map[string]string{"de-de": "PRO5070TIFE,PRO5080FE,PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": false, "de-de/5080": true, "de-de/5070-ti": false},
+		},
+		{
+			name: "locales are requested separately",
+			skus: map[string]string{"de-de/5090": "PRO5090FE", "fr-fr/5090": "PRO5090FE", "fr-fr/5080": "PRO5080FE"},
+			items: map[string][]InventoryItem{
+				"de-de": {{FeSKU: "PRO5090FE", IsActive: "false"}},
+				"fr-fr": {{FeSKU: "PRO5090FE", IsActive: "true"}, {FeSKU: "PRO5080FE", IsActive: "false"}},
+			},
+			queries: map[string]string{"de-de": "PRO5090FE", "fr-fr": "PRO5080FE,PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": false, "fr-fr/5090": true, "fr-fr/5080": false},
+		},
+		{
+			name: "listings match SKUs case-insensitively",
+			skus: map[string]string{"de-de/5090": "PRO5090FE", "de-de/5080": "PRO5080FE"},
+			items: map[string][]InventoryItem{"de-de": {
+				{FeSKU: "pro5090fe", IsActive: "true"},
+				{FeSKU: "PRO5080FE", IsActive: "false"},
+			}},
+			queries: map[string]string{"de-de": "PRO5080FE,PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": true, "de-de/5080": false},
+		},
+		{
+			name: "listings without SKU are ambiguous for several SKUs",
+			skus: map[string]string{"de-de/5090": "PRO5090FE", "de-de/5080": "PRO5080FE"},
+			items: map[string][]InventoryItem{"de-de": {
+				{IsActive: "true"},
+			}},
+			queries: map[string]string{"de-de": "PRO5080FE,PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": false, "de-de/5080": false},
+		},
+		{
+			name: "a single SKU request may omit the SKU",
+			skus: map[string]string{"de-de/5090": "PRO5090FE", "de-de/5080": ""},
+			items: map[string][]InventoryItem{"de-de": {
+				{IsActive: "true"},
+			}},
+			queries: map[string]string{"de-de": "PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": true, "de-de/5080": false},
+		},
+		{
+			name:    "targets with the same SKU get the same listing",
+			skus:    map[string]string{"de-de/5090": "PRO5090FE", "de-de/5090-d": "PRO5090FE"},
+			items:   map[string][]InventoryItem{"de-de": {{FeSKU: "PRO5090FE", IsActive: "true"}}},
+			queries: map[string]string{"de-de": "PRO5090FE"},
+			want:    map[string]bool{"de-de/5090": true, "de-de/5090-d": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := make(map[string]string)
+			stubUpstream(t, func(r *http.Request) interface{} {
+				locale := r.URL.Query().Get("locale")
+				queries[locale] = r.URL.Query().Get("skus")
+				return InventoryResponse{ListMap: tt.items[locale]}
+			})
+
+			byLocale := make(map[string][]*Monitor)
+			monitors := make(map[string]*Monitor)
+			for id, sku := range tt.skus {
+				locale, model, _ := strings.Cut(id, "/")
+				m := testMonitor(t, fmt.Sprintf(base, locale, model))
+				m.updateSKU(sku)
+				byLocale[locale] = append(byLocale[locale], m)
+				monitors[id] = m
+			}
+			for locale, group := range byLocale {
+				sort.Slice(group, func(i, j int) bool { return group[i].currentSKU < group[j].currentSKU })
+				checkLocaleStock(context.Background(), locale, group)
+			}
+
+			if fmt.Sprint(queries) != fmt.Sprint(tt.queries) {
+				t.Errorf("requested %v, want %v", queries, tt.queries)
+			}
+			for id, want := range tt.want {
+				if got := monitors[id].status().InStock; got != want {
+					t.Errorf("%s in stock = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderProfile is the browser fingerprint sent with an upstream request
+type HeaderProfile struct {
+	Name            string
+	UserAgent       string
+	SecChUa         string // Client hints, only sent by Chromium browsers
+	SecChUaPlatform string
+}
+
+// Built-in profiles selectable with HEADER_PROFILES
+var headerProfiles = []HeaderProfile{
+	{
+		Name:            "chrome-windows",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		SecChUa:         `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+		SecChUaPlatform: `"Windows"`,
+	},
+	{
+		Name:            "chrome-mac",
+		UserAgent:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		SecChUa:         `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+		SecChUaPlatform: `"macOS"`,
+	},
+	{
+		Name:            "edge-windows",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+		SecChUa:         `"Not_A Brand";v="8", "Chromium";v="120", "Microsoft Edge";v="120"`,
+		SecChUaPlatform: `"Windows"`,
+	},
+	{
+		Name:      "firefox-windows",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	},
+	{
+		Name:      "safari-mac",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+	},
+}
+
+const DEFAULT_HEADER_ROTATION = "round-robin"
+
+// UpstreamClient sends every request to the NVIDIA APIs, rotating through
+// the header profiles
+type UpstreamClient struct {
+	profiles []HeaderProfile
+	random   bool
+	next     int
+	mu       sync.Mutex
+}
+
+var upstream = &UpstreamClient{profiles: headerProfiles}
+
+// Read HEADER_PROFILES (comma separated names, default all) and
+// HEADER_ROTATION
+func loadUpstreamClient() (*UpstreamClient, error) {
+	rotation := setting("HEADER_ROTATION")
+	if rotation == "" {
+		rotation = DEFAULT_HEADER_ROTATION
+	}
+	if rotation != "round-robin" && rotation != "random" {
+		return nil, fmt.Errorf("invalid HEADER_ROTATION %q, use round-robin or random", rotation)
+	}
+
+	c := &UpstreamClient{random: rotation == "random"}
+	names := setting("HEADER_PROFILES")
+	if names == "" {
+		c.profiles = headerProfiles
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			profile, ok := findHeaderProfile(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown header profile %q", name)
+			}
+			c.profiles = append(c.profiles, profile)
+		}
+	}
+
+	profileNames := make([]string, len(c.profiles))
+	for i, profile := range c.profiles {
+		profileNames[i] = profile.Name
+	}
+	log.Printf("- HEADER_PROFILES: %s (%s)", strings.Join(profileNames, ", "), rotation)
+	return c, nil
+}
+
+func findHeaderProfile(name string) (HeaderProfile, bool) {
+	for _, profile := range headerProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return HeaderProfile{}, false
+}
+
+// Next profile in rotation
+func (c *UpstreamClient) profile() HeaderProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.random {
+		return c.profiles[rand.Intn(len(c.profiles))]
+	}
+	profile := c.profiles[c.next]
+	c.next = (c.next + 1) % len(c.profiles)
+	return profile
+}
+
+// Accept-Language for a marketplace locale like "de-de"
+func acceptLanguage(locale string) string {
+	lang, region, _ := strings.Cut(locale, "-")
+	tag := lang + "-" + strings.ToUpper(region)
+	if lang == "en" {
+		return tag + ",en;q=0.9"
+	}
+	return tag + "," + lang + ";q=0.9,en-US;q=0.8,en;q=0.7"
+}
+
+// Set the headers a browser on the marketplace page of target would send
+func (p HeaderProfile) apply(req *http.Request, target Target) {
+	req.Header.Set("User-Agent", p.UserAgent)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("Accept-Language", acceptLanguage(target.Locale))
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Origin", "https://marketplace.nvidia.com")
+	req.Header.Set("Referer", target.ProductURL)
+	req.Header.Set("Sec-Fetch-Dest", "empty")
+	req.Header.Set("Sec-Fetch-Mode", "cors")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	if p.SecChUa != "" {
+		req.Header.Set("Sec-Ch-Ua", p.SecChUa)
+		req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
+		req.Header.Set("Sec-Ch-Ua-Platform", p.SecChUaPlatform)
+	}
+}
+
+// Send an upstream request and record its status code and latency
+func doUpstream(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	do := client.Do
+	if proxyPool != nil {
+		do = proxyPool.Do
+	}
+	resp, err := do(req)
+	upstreamDuration.Observe(time.Since(start).Seconds(), endpoint)
+
+	if err != nil {
+		upstreamRequests.Inc(endpoint, "error")
+		return nil, err
+	}
+	upstreamRequests.Inc(endpoint, strconv.Itoa(resp.StatusCode))
+	upstreamBackoff.Observe(resp)
+	if resp.StatusCode == http.StatusOK {
+		metrics.updateLastSuccess()
+	}
+	return resp, nil
+}
+
+// GetJSON requests url for target with the next header profile and decodes
+// the JSON response into v
+func (c *UpstreamClient) GetJSON(ctx context.Context, endpoint, url string, target Target, v interface{}) error {
+	metrics.incrementApiRequests()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	c.profile().apply(req, target)
+
+	resp, err := doUpstream(req, endpoint)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error parsing JSON: %w", err)
+	}
+	return nil
+}
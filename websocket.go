@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 6455 constants
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Connection limits
+const (
+	wsMaxMessageSize = 64 * 1024
+	wsWriteTimeout   = 10 * time.Second
+	wsReadTimeout    = 3 * hubPingInterval // Clients answer the hub pings
+)
+
+// wsConn is a minimal server side WebSocket connection, enough for the
+// small JSON messages of /ws
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// Upgrade an HTTP request to a WebSocket connection. On failure the error
+// has been sent to the client and nil is returned.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil
+	}
+	if !allowedOrigin(r) {
+		http.Error(w, "cross-origin websocket not allowed", http.StatusForbidden)
+		return nil
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("WebSocket hijack failed: %v", err)
+		return nil
+	}
+	// Drop the deadlines the HTTP server may have set
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		log.Printf("WebSocket handshake failed: %v", err)
+		conn.Close()
+		return nil
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader}
+}
+
+// Whether a comma separated header contains token, ignoring case
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Browsers send the Origin of the page opening a WebSocket. Only the
+// tracker's own pages, served from the requested host or PUBLIC_URL, may
+// connect, so other sites can't send ack or recheck requests. Clients
+// without an Origin are not browsers and are allowed.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	public, err := url.Parse(setting("PUBLIC_URL"))
+	return err == nil && public.Host != "" && strings.EqualFold(u.Host, public.Host)
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// close frames on the way. Returns io.EOF once the peer closed.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.WriteMessage(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.WriteMessage(wsOpClose, payload)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+		default:
+			return nil, fmt.Errorf("unknown opcode %d", opcode)
+		}
+
+		message = append(message, payload...)
+		if len(message) > wsMaxMessageSize {
+			return nil, fmt.Errorf("message too large")
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, fmt.Errorf("frame too large")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a single unmasked frame, safe for concurrent use
+func (c *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteJSON sends v as a text message
+func (c *wsConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(wsOpText, data)
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// Client to server message on /ws
+type wsRequest struct {
+	Type    string   `json:"type"`    // subscribe, ack or recheck
+	Targets []string `json:"targets"` // subscribe: targets to follow, empty for all
+	Target  string   `json:"target"`  // ack, recheck: target ID, recheck: empty for all
+}
+
+// Answer to a wsRequest
+type wsReply struct {
+	Type    string `json:"type"` // Always "reply"
+	Request string `json:"request"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Handle /ws: the /events stream over a WebSocket, plus subscribe, ack and
+// recheck requests from the client
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn := upgradeWebSocket(w, r)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	lastEventID, resume := parseLastEventID(r)
+
+	if err := conn.WriteJSON(map[string]string{"type": "connected"}); err != nil {
+		return
+	}
+
+	id, frames := hub.Subscribe(lastEventID, resume)
+	defer hub.Unsubscribe(id)
+
+	// Requests are read in the background, the frames are written here
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("WebSocket client %d: %v", id, err)
+				}
+				return
+			}
+			if err := conn.WriteJSON(handleWebSocketRequest(id, message)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				conn.WriteMessage(wsOpClose, nil) // Dropped by the hub or shutting down
+				return
+			}
+			opcode, payload := byte(wsOpText), frame.ws
+			if frame.id == 0 {
+				opcode, payload = wsOpPing, nil
+			}
+			if err := conn.WriteMessage(opcode, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func handleWebSocketRequest(clientID uint64, message []byte) wsReply {
+	var req wsRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return wsReply{Type: "reply", Error: "invalid JSON"}
+	}
+
+	reply := wsReply{Type: "reply", Request: req.Type}
+	var err error
+	switch req.Type {
+	case "subscribe":
+		var targets map[string]bool
+		if len(req.Targets) > 0 {
+			targets = make(map[string]bool)
+			for _, target := range req.Targets {
+				if scheduler.monitor(target) == nil {
+					err = fmt.Errorf("unknown target %q", target)
+					break
+				}
+				targets[target] = true
+			}
+		}
+		if err == nil {
+			hub.Follow(clientID, targets)
+		}
+	case "ack":
+		err = scheduler.Acknowledge(req.Target)
+	case "recheck":
+		err = scheduler.Recheck(req.Target)
+	default:
+		err = fmt.Errorf("unknown request type %q", req.Type)
+	}
+
+	if err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.OK = true
+	}
+	return reply
+}